@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"snippetbox.mabona3.net/internal/mailer"
+	"snippetbox.mabona3.net/internal/models"
+	"snippetbox.mabona3.net/internal/validator"
+)
+
+type accountNameForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+type accountEmailForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type accountPasswordForm struct {
+	CurrentPassword     string `form:"currentPassword"`
+	NewPassword         string `form:"newPassword"`
+	Confirm             string `form:"confirm"`
+	validator.Validator `form:"-"`
+}
+
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// accountUser returns the signed-in user.
+func (a *application) accountUser(r *http.Request) (*models.User, error) {
+	return a.users.Get(r.Context(), a.authenticatedUserID(r))
+}
+
+// confirmPassword checks the password a signed-in user gave to confirm a
+// change to their account, returning models.ErrInvalidCredentials if it is
+// wrong. It is throttled like a login, so that whoever holds the session
+// can't use it to guess the password, and reports how long the user must
+// wait instead when they have guessed too often. Accounts created through
+// single sign-on have no password to confirm with, so they go ahead.
+func (a *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) (time.Duration, error) {
+	if !user.HasPassword {
+		return 0, nil
+	}
+
+	ip := a.clientIP(r)
+
+	wait, err := a.loginThrottle.attempt(r.Context(), user.Email, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		return wait, nil
+	}
+
+	err = a.users.CheckPassword(r.Context(), user.ID, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		return 0, err
+	} else if err != nil {
+		releaseErr := a.loginThrottle.release(r.Context(), user.Email, ip)
+		if releaseErr != nil {
+			return 0, releaseErr
+		}
+		return 0, err
+	}
+
+	return 0, a.loginThrottle.succeeded(r.Context(), user.Email, ip)
+}
+
+// checkConfirmation adds the outcome of confirmPassword to a form as an
+// error against field, and returns the status to show the form again with.
+func checkConfirmation(v *validator.Validator, field, incorrect string, wait time.Duration, err error) int {
+	if wait > 0 {
+		v.AddFieldError(field, fmt.Sprintf("Too many failed attempts. Please try again in %s.", retryMessage(wait)))
+		return http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		v.AddFieldError(field, incorrect)
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func (a *application) accountView(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	pending, err := a.emailVerifications.PendingEmail(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.User = user
+	data.PendingEmail = pending
+	a.render(w, r, http.StatusOK, "account.html", data)
+}
+
+func (a *application) accountName(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Form = accountNameForm{Name: user.Name}
+	a.render(w, r, http.StatusOK, "changename.html", data)
+}
+
+func (a *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	var form accountNameForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "changename.html", data)
+		return
+	}
+
+	err = a.users.UpdateName(r.Context(), a.authenticatedUserID(r), form.Name)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Your name has been updated.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) accountEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.User = user
+	data.Form = accountEmailForm{}
+	a.render(w, r, http.StatusOK, "changeemail.html", data)
+}
+
+// accountEmailPost starts a change of email address. The new address has
+// to be verified before it replaces the old one, so a typo can't lock the
+// user out of their account, and the user has to confirm the change with
+// their password, so nobody can take it over from a session left signed in.
+func (a *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	var form accountEmailForm
+
+	err = a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Email = strings.TrimSpace(form.Email)
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(form.Email != user.Email, "email", "This is already your email address")
+
+	status := http.StatusUnprocessableEntity
+
+	// The password is checked first, so the form can't be used to find out
+	// which addresses have accounts without it.
+	if form.Valid() {
+		wait, err := a.confirmPassword(w, r, user, form.Password)
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			a.serverError(w, r, err)
+			return
+		}
+
+		status = checkConfirmation(&form.Validator, "password", "Password is incorrect", wait, err)
+	}
+
+	if form.Valid() {
+		_, err = a.users.GetByEmail(r.Context(), form.Email)
+		if err == nil {
+			form.AddFieldError("email", "Email Address is already in use")
+		} else if !errors.Is(err, models.ErrNoRecord) {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.User = user
+		data.Form = form
+		a.render(w, r, status, "changeemail.html", data)
+		return
+	}
+
+	err = a.sendEmailChangeEmail(r, user, form.Email)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash(fmt.Sprintf("We've emailed a link to %s. Follow it to confirm your new address.", form.Email))
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// sendEmailChangeEmail emails a link to the user's new address that
+// confirms the change. Like sendVerificationEmail, failing to send is only
+// logged.
+func (a *application) sendEmailChangeEmail(r *http.Request, user *models.User, email string) error {
+	token, err := a.emailVerifications.InsertEmailChange(r.Context(), user.ID, email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	err = a.mailer.Send(r.Context(), mailer.Message{
+		To:      email,
+		Subject: "Confirm your new Snippetbox email address",
+		Body: fmt.Sprintf(`Hi %s,
+
+You asked to change the email address of your Snippetbox account to this
+one. To confirm, open this link within the next 24 hours:
+
+%s/user/verify/%s
+
+If you didn't ask for this, you can ignore this email.
+`, user.Name, a.baseURL, token),
+	})
+	if err != nil {
+		a.logger.Error("sending email change email", "request_id", requestID(r), "error", err)
+	}
+
+	return nil
+}
+
+func (a *application) accountPassword(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.User = user
+	data.Form = accountPasswordForm{}
+	a.render(w, r, http.StatusOK, "changepassword.html", data)
+}
+
+func (a *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	var form accountPasswordForm
+
+	err = a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if user.HasPassword {
+		form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	}
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(form.Confirm == form.NewPassword, "confirm", "The passwords do not match")
+
+	status := http.StatusUnprocessableEntity
+
+	if form.Valid() {
+		wait, err := a.confirmPassword(w, r, user, form.CurrentPassword)
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			a.serverError(w, r, err)
+			return
+		}
+
+		status = checkConfirmation(&form.Validator, "currentPassword", "Current password is incorrect", wait, err)
+	}
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.User = user
+		data.Form = form
+		a.render(w, r, status, "changepassword.html", data)
+		return
+	}
+
+	err = a.users.UpdatePassword(r.Context(), user.ID, form.NewPassword)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password may be signed in elsewhere, so every
+	// session ends, and this one starts again.
+	_, err = a.sessions.DeleteForUser(r.Context(), user.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.startAuthSession(w, r, user.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Your password has been changed, and you've been logged out on your other devices.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.User = user
+	data.Form = accountDeleteForm{}
+	a.render(w, r, http.StatusOK, "deleteaccount.html", data)
+}
+
+// accountDeletePost deletes the user's account and snippets, once they have
+// confirmed it with their password, and signs them out everywhere.
+func (a *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	user, err := a.accountUser(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	var form accountDeleteForm
+
+	err = a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	wait, err := a.confirmPassword(w, r, user, form.Password)
+	if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	status := checkConfirmation(&form.Validator, "password", "Password is incorrect", wait, err)
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.User = user
+		data.Form = form
+		a.render(w, r, status, "deleteaccount.html", data)
+		return
+	}
+
+	err = a.users.Delete(r.Context(), user.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// The sessions went with the account, but the cookie is still there.
+	authsession, err := a.Store.Get(r, "authsession")
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	authsession.Options.MaxAge = -1
+	authsession.Save(r, w)
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Your account and snippets have been deleted.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
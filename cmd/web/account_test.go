@@ -0,0 +1,352 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+)
+
+func TestAccountView(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account/view")
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/view")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Alice")
+	assert.StringContains(t, body, "alice@example.com")
+	assert.StringContains(t, body, "02 Jan 2024 at 03:04")
+}
+
+// postAccountForm logs in as Alice and posts form to one of the account
+// settings pages, after loading it for its CSRF token.
+func postAccountForm(t *testing.T, ts *testServer, urlPath string, form url.Values) (int, http.Header, string) {
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, urlPath)
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	return ts.postForm(t, urlPath, form)
+}
+
+func TestAccountNamePost(t *testing.T) {
+	tests := []struct {
+		name     string
+		newName  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid name",
+			newName:  "Alice Jones",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Blank name",
+			newName:  "  ",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			code, header, body := postAccountForm(t, ts, "/account/name", url.Values{"name": {tt.newName}})
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/account/view")
+			} else {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountEmailPost(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "New address",
+			email:    "alice@new.example.com",
+			password: "pa$$word",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Wrong password",
+			email:    "alice@new.example.com",
+			password: "wrong",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Password is incorrect",
+		},
+		{
+			name:     "Wrong password for address in use",
+			email:    "carol@example.com",
+			password: "wrong",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Password is incorrect",
+		},
+		{
+			name:     "Same address",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This is already your email address",
+		},
+		{
+			name:     "Address in use",
+			email:    "carol@example.com",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Email Address is already in use",
+		},
+		{
+			name:     "Invalid address",
+			email:    "alice@",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid email address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			code, header, body := postAccountForm(t, ts, "/account/email", url.Values{"email": {tt.email}, "password": {tt.password}})
+
+			assert.Equal(t, code, tt.wantCode)
+
+			sent := a.mailer.(*recordingMailer).messages()
+
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/account/view")
+
+				// The link goes to the new address, not the old one.
+				assert.Equal(t, len(sent), 1)
+				assert.Equal(t, sent[0].To, tt.email)
+				assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/verify/"+mocks.MockVerificationToken)
+			} else {
+				assert.Equal(t, len(sent), 0)
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountPasswordPost(t *testing.T) {
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		confirm         string
+		wantCode        int
+		wantBody        string
+	}{
+		{
+			name:            "Valid change",
+			currentPassword: "pa$$word",
+			newPassword:     "new pa$$word",
+			confirm:         "new pa$$word",
+			wantCode:        http.StatusSeeOther,
+		},
+		{
+			name:            "Wrong current password",
+			currentPassword: "wrong",
+			newPassword:     "new pa$$word",
+			confirm:         "new pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Current password is incorrect",
+		},
+		{
+			name:            "Short new password",
+			currentPassword: "pa$$word",
+			newPassword:     "short",
+			confirm:         "short",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "This field must be at least 8 characters long",
+		},
+		{
+			name:            "Mismatched confirmation",
+			currentPassword: "pa$$word",
+			newPassword:     "new pa$$word",
+			confirm:         "other pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "The passwords do not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			form := url.Values{
+				"currentPassword": {tt.currentPassword},
+				"newPassword":     {tt.newPassword},
+				"confirm":         {tt.confirm},
+			}
+
+			code, header, body := postAccountForm(t, ts, "/account/password", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/account/view")
+
+				// This session carries on under a new ID.
+				code, _, _ = ts.get(t, "/account/view")
+				assert.Equal(t, code, http.StatusOK)
+			} else {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountDeletePost(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantCode int
+	}{
+		{
+			name:     "Correct password",
+			password: "pa$$word",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Wrong password",
+			password: "wrong",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			code, header, body := postAccountForm(t, ts, "/account/delete", url.Values{"password": {tt.password}})
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/")
+
+				code, _, _ = ts.get(t, "/account/view")
+				assert.Equal(t, code, http.StatusSeeOther)
+			} else {
+				assert.StringContains(t, body, "Password is incorrect")
+			}
+		})
+	}
+}
+
+func TestAccountPasswordConfirmationThrottled(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	attempt := func(password string) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/account/delete")
+
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+		return ts.postForm(t, "/account/delete", form)
+	}
+
+	for range a.loginThrottle.account.free + 1 {
+		code, _, _ := attempt("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	code, header, body := attempt("pa$$word")
+
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "60")
+	assert.StringContains(t, body, "Too many failed attempts. Please try again in")
+}
+
+func TestAccountSingleSignOnUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantLocation string
+	}{
+		{
+			name:         "Change email",
+			urlPath:      "/account/email",
+			form:         url.Values{"email": {"frank@new.example.com"}},
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Set password",
+			urlPath:      "/account/password",
+			form:         url.Values{"newPassword": {"new pa$$word"}, "confirm": {"new pa$$word"}},
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Delete account",
+			urlPath:      "/account/delete",
+			form:         url.Values{},
+			wantLocation: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeOIDCProvider(t)
+			provider.setUser("frank-sub", "frank@example.com", true)
+
+			ts := newOIDCTestServer(t, provider)
+			defer ts.Close()
+
+			ts.get(t, loginAtProvider(t, ts, provider))
+
+			// Frank has no password of his own, so he isn't asked for one.
+			_, _, body := ts.get(t, tt.urlPath)
+			if strings.Contains(body, `autocomplete="current-password"`) {
+				t.Error("asked for a password the account doesn't have")
+			}
+
+			tt.form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, tt.urlPath, tt.form)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"snippetbox.mabona3.net/internal/models"
+	"snippetbox.mabona3.net/internal/validator"
+)
+
+type envelope map[string]any
+
+type apiError struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (a *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	page, ok := readPage(r)
+	if !ok {
+		a.apiClientError(w, http.StatusBadRequest, fmt.Sprintf("page must be an integer between 1 and %d", maxPage))
+		return
+	}
+
+	snippets, pagination, err := a.snippets.List(r.Context(), page, snippetsPerPage)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, envelope{"snippets": snippets, "metadata": pagination}, nil)
+}
+
+func (a *application) apiSnippetGet(w http.ResponseWriter, r *http.Request) {
+	id, ok := readIDParam(r)
+	if !ok {
+		a.apiClientError(w, http.StatusBadRequest, "id must be a positive integer")
+		return
+	}
+
+	snippet, err := a.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.apiNotFound(w)
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, envelope{"snippet": snippet}, nil)
+}
+
+func (a *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var form snippetCreateForm
+
+	err := a.readJSON(w, r, &form)
+	if err != nil {
+		a.apiClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if form.Language == "" {
+		form.Language = "plaintext"
+	}
+
+	form.validate()
+
+	if !form.Valid() {
+		a.apiFailedValidation(w, form.Validator)
+		return
+	}
+
+	id, err := a.snippets.Insert(r.Context(), a.authenticatedUserID(r), form.Title, form.Content, form.Language, form.Expires)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/snippets/%d", id))
+
+	a.writeJSON(w, http.StatusCreated, envelope{"id": id}, headers)
+}
+
+func (a *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := readIDParam(r)
+	if !ok {
+		a.apiClientError(w, http.StatusBadRequest, "id must be a positive integer")
+		return
+	}
+
+	snippet, err := a.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.apiNotFound(w)
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	if snippet.UserID != a.authenticatedUserID(r) {
+		a.apiClientError(w, http.StatusForbidden, "you do not own this snippet")
+		return
+	}
+
+	err = a.snippets.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.apiNotFound(w)
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		a.logger.Error(err.Error(), "trace", string(debug.Stack()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+func (a *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+func (a *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		a.logger.Warn(err.Error(),
+			"request_id", requestID(r),
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+		)
+
+		headers := make(http.Header)
+		headers.Set("Retry-After", "5")
+		a.writeJSON(w, http.StatusServiceUnavailable, envelope{"error": apiError{
+			Message: "the server is temporarily unable to handle your request, please try again later",
+		}}, headers)
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		a.clientGone(r, err)
+		return
+	}
+
+	a.logger.Error(err.Error(),
+		"request_id", requestID(r),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"trace", string(debug.Stack()),
+	)
+
+	a.apiClientError(w, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+}
+
+func (a *application) apiClientError(w http.ResponseWriter, status int, message string) {
+	a.writeJSON(w, status, envelope{"error": apiError{Message: message}}, nil)
+}
+
+func (a *application) apiNotFound(w http.ResponseWriter) {
+	a.apiClientError(w, http.StatusNotFound, "the requested resource could not be found")
+}
+
+func (a *application) apiAuthenticationRequired(w http.ResponseWriter, message string) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="snippetbox"`)
+	w.Header().Add("WWW-Authenticate", `Basic realm="snippetbox"`)
+	a.apiClientError(w, http.StatusUnauthorized, message)
+}
+
+func (a *application) apiFailedValidation(w http.ResponseWriter, v validator.Validator) {
+	a.writeJSON(w, http.StatusUnprocessableEntity, envelope{"error": apiError{
+		Message: "the request failed validation",
+		Fields:  v.FieldErrors,
+	}}, nil)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+)
+
+func TestAPISnippetGet(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/api/v1/snippets/1",
+			wantCode: http.StatusOK,
+			wantBody: `"title":"An old silent pond"`,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/api/v1/snippets/2",
+			wantCode: http.StatusNotFound,
+			wantBody: `"message":"the requested resource could not be found"`,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/api/v1/snippets/foo",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "List",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"total_records":1`,
+		},
+		{
+			name:     "Huge page",
+			urlPath:  "/api/v1/snippets?page=1000000000000000000",
+			wantCode: http.StatusBadRequest,
+			wantBody: `"message":"page must be an integer between 1 and 10000"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Content-Type"), "application/json")
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		token    string
+		email    string
+		password string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission with token",
+			token:    mocks.MockToken,
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Invalid token",
+			token:    "sbx_wrong",
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Deleted user's token",
+			token:    mocks.MockDeletedUserToken,
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Valid submission",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusCreated,
+			wantBody: `{"id":2}`,
+		},
+		{
+			name:     "Unauthenticated",
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Wrong password",
+			email:    "alice@example.com",
+			password: "wrong",
+			body:     `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Invalid fields",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			body:     `{"title": "", "content": "Climb Mount Fuji", "expires": 3}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"fields":{"expires":"This firld must equal 1, 7 or 365","title":"This field cannot be blank"}`,
+		},
+		{
+			name:     "Unknown field",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			body:     `{"title": "O snail", "author": "Issa"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `body contains unknown field \"author\"`,
+		},
+		{
+			name:     "Badly-formed JSON",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			body:     `{"title": "O snail",`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/snippets", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.email != "" {
+				r.SetBasicAuth(tt.email, tt.password)
+			}
+
+			code, _, body := ts.do(t, r)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPISnippetDelete(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Owner",
+			urlPath:  "/api/v1/snippets/1",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Not owner",
+			urlPath:  "/api/v1/snippets/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/api/v1/snippets/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodDelete, ts.URL+tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.SetBasicAuth("alice@example.com", "pa$$word")
+
+			code, _, _ := ts.do(t, r)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
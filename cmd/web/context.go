@@ -3,4 +3,6 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
 const sessionContextKey = contextKey("session")
+const requestIDContextKey = contextKey("requestID")
@@ -3,26 +3,83 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/julienschmidt/httprouter"
+	"snippetbox.mabona3.net/internal/mailer"
 	"snippetbox.mabona3.net/internal/models"
 	"snippetbox.mabona3.net/internal/validator"
 )
 
+const snippetsPerPage = 10
+
+// passwordResetTTL is how long an emailed password reset link stays valid.
+const passwordResetTTL = time.Hour
+
+// emailVerificationTTL is how long an emailed verification link stays valid.
+const emailVerificationTTL = 24 * time.Hour
+
+// snippetLanguages are the languages a snippet can be tagged with. Each is
+// the name of the chroma lexer used to highlight it.
+var snippetLanguages = []string{
+	"plaintext", "bash", "c", "cpp", "csharp", "css", "diff", "docker", "go",
+	"html", "java", "javascript", "json", "kotlin", "lua", "makefile",
+	"markdown", "nginx", "php", "python", "ruby", "rust", "sql", "swift",
+	"toml", "typescript", "xml", "yaml",
+}
+
 type snippetCreateForm struct {
+	Title               string `json:"title"`
+	Content             string `json:"content"`
+	Language            string `json:"language"`
+	Expires             int    `json:"expires"`
+	validator.Validator `form:"-" json:"-"`
+}
+
+func (form *snippetCreateForm) validate() {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PremittedValue(form.Language, snippetLanguages...), "language", "This field must be a supported language")
+	form.CheckField(validator.PremittedValue(form.Expires, 1, 7, 365), "expires", "This firld must equal 1, 7 or 365")
+}
+
+type snippetEditForm struct {
 	Title               string
 	Content             string
-	Expires             int
+	Language            string
+	validator.Validator `form:"-"`
+}
+
+type tokenCreateForm struct {
+	Name                string `form:"name"`
 	validator.Validator `form:"-"`
 }
 
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	Unverified          bool   `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+type verificationResendForm struct {
+	Email string `form:"email"`
+}
+
+type passwordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type passwordResetForm struct {
+	Password            string `form:"password"`
+	Confirm             string `form:"confirm"`
 	validator.Validator `form:"-"`
 }
 
@@ -38,23 +95,71 @@ func ping(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *application) home(w http.ResponseWriter, r *http.Request) {
+	page, ok := readPage(r)
+	if !ok {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
 
-	snippets, err := a.snippets.Latest()
+	snippets, pagination, err := a.snippets.List(r.Context(), page, snippetsPerPage)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
 
 	data := a.newTemplateData(w, r)
 	data.Snippets = snippets
+	data.Pagination = pagination
+
+	a.render(w, r, http.StatusOK, "home.html", data)
+}
+
+func (a *application) snippetSearch(w http.ResponseWriter, r *http.Request) {
+	page, ok := readPage(r)
+	if !ok {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if !validator.MaxChars(query, 100) {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Query = query
+
+	if query != "" {
+		// Ask for one extra row so we know whether there is a next page
+		// without having to count every match.
+		snippets, err := a.snippets.Search(r.Context(), query, snippetsPerPage+1, (page-1)*snippetsPerPage)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		lastPage := page
+		if len(snippets) > snippetsPerPage {
+			snippets = snippets[:snippetsPerPage]
+			lastPage++
+		}
 
-	a.render(w, http.StatusOK, "home.html", data)
+		data.Snippets = snippets
+		data.Pagination = models.Pagination{
+			CurrentPage: page,
+			PageSize:    snippetsPerPage,
+			LastPage:    lastPage,
+		}
+	}
+
+	a.render(w, r, http.StatusOK, "search.html", data)
 }
 
 func (a *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	session := r.Context().Value(sessionContextKey).(*sessions.Session)
 	if session == nil {
-		a.serverError(w, errors.New("No Session Initialized"))
+		a.serverError(w, r, errors.New("No Session Initialized"))
 		return
 	}
 
@@ -66,12 +171,12 @@ func (a *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := a.snippets.Get(id)
+	snippet, err := a.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			a.notFound(w)
 		} else {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 		}
 		return
 	}
@@ -81,20 +186,48 @@ func (a *application) snippetView(w http.ResponseWriter, r *http.Request) {
 
 	err = session.Save(r, w)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
+		return
+	}
+	a.render(w, r, http.StatusOK, "view.html", data)
+}
+
+func (a *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := a.readSnippet(w, r)
+	if !ok {
 		return
 	}
-	a.render(w, http.StatusOK, "view.html", data)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", snippetETag(snippet))
+
+	http.ServeContent(w, r, "", snippet.LastModified(), strings.NewReader(snippet.Content))
+}
+
+func (a *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := a.readSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": snippetFilename(snippet),
+	}))
+	w.Header().Set("ETag", snippetETag(snippet))
+
+	http.ServeContent(w, r, "", snippet.LastModified(), strings.NewReader(snippet.Content))
 }
 
 func (a *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data := a.newTemplateData(w, r)
 
 	data.Form = snippetCreateForm{
-		Expires: 365,
+		Language: "plaintext",
+		Expires:  365,
 	}
 
-	a.render(w, http.StatusOK, "create.html", data)
+	a.render(w, r, http.StatusOK, "create.html", data)
 }
 
 func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
@@ -102,7 +235,62 @@ func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request)
 
 	session := r.Context().Value(sessionContextKey).(*sessions.Session)
 	if session == nil {
-		a.serverError(w, models.ErrSessionNotFound)
+		a.serverError(w, r, models.ErrSessionNotFound)
+		return
+	}
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.validate()
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+
+	id, err := a.snippets.Insert(r.Context(), a.authenticatedUserID(r), form.Title, form.Content, form.Language, form.Expires)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session.AddFlash("Snippet successfully created!")
+	err = session.Save(r, w)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+func (a *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := a.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Snippet = snippet
+	data.Form = snippetEditForm{
+		Title:    snippet.Title,
+		Content:  snippet.Content,
+		Language: snippet.Language,
+	}
+
+	a.render(w, r, http.StatusOK, "edit.html", data)
+}
+
+func (a *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetEditForm
+
+	snippet, ok := a.ownedSnippet(w, r)
+	if !ok {
 		return
 	}
 
@@ -115,28 +303,60 @@ func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request)
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PremittedValue(form.Expires, 1, 7, 365), "expires", "This firld must equal 1, 7 or 365")
+	form.CheckField(validator.PremittedValue(form.Language, snippetLanguages...), "language", "This field must be a supported language")
 
 	if !form.Valid() {
 		data := a.newTemplateData(w, r)
+		data.Snippet = snippet
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "create.html", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
 		return
 	}
 
-	id, err := a.snippets.Insert(form.Title, form.Content, form.Expires)
+	err = a.snippets.Update(r.Context(), snippet.ID, form.Title, form.Content, form.Language)
 	if err != nil {
-		a.serverError(w, err)
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
 		return
 	}
 
-	session.AddFlash("Snippet successfully created!")
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Snippet successfully updated!")
 	err = session.Save(r, w)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (a *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := a.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.snippets.Delete(r.Context(), snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Snippet successfully deleted!")
+	err = session.Save(r, w)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (a *application) Neuter(next http.Handler) http.Handler {
@@ -153,7 +373,7 @@ func (a *application) Neuter(next http.Handler) http.Handler {
 func (a *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := a.newTemplateData(w, r)
 	data.Form = userSignupForm{}
-	a.render(w, http.StatusOK, "signup.html", data)
+	a.render(w, r, http.StatusOK, "signup.html", data)
 }
 
 func (a *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
@@ -174,26 +394,32 @@ func (a *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := a.newTemplateData(w, r)
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		return
 	}
 
-	err = a.users.Insert(form.Name, form.Email, form.Password)
+	id, err := a.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email Address is already in use")
 			data := a.newTemplateData(w, r)
 			data.Form = form
-			a.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+			a.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		} else {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 		}
 
 		return
 	}
 
+	err = a.sendVerificationEmail(r, &models.User{ID: id, Name: form.Name, Email: form.Email})
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
 	session := r.Context().Value(sessionContextKey).(*sessions.Session)
-	session.AddFlash("Your signup was successful . Please Login.")
+	session.AddFlash("Your signup was successful. We've emailed you a link to verify your address; follow it, then log in.")
 	session.Save(r, w)
 
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -202,7 +428,7 @@ func (a *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 func (a *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := a.newTemplateData(w, r)
 	data.Form = userLoginForm{}
-	a.render(w, http.StatusOK, "login.html", data)
+	a.render(w, r, http.StatusOK, "login.html", data)
 }
 
 func (a *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -221,33 +447,89 @@ func (a *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := a.newTemplateData(w, r)
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "login.html", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
+	}
+
+	ip := a.clientIP(r)
+
+	wait, err := a.loginThrottle.attempt(r.Context(), form.Email, ip)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		form.AddNonFieldError(fmt.Sprintf("Too many failed login attempts. Please try again in %s.", retryMessage(wait)))
+
+		setRetryAfter(w, wait)
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusTooManyRequests, "login.html", data)
 		return
 	}
 
-	id, err := a.users.Authenticate(form.Email, form.Password)
+	id, err := a.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
+		// The attempt was counted as a failure, which only a wrong password
+		// should be.
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			releaseErr := a.loginThrottle.release(r.Context(), form.Email, ip)
+			if releaseErr != nil {
+				a.serverError(w, r, releaseErr)
+				return
+			}
+		}
+
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			form.AddNonFieldError("Email or password is incorrect")
 
 			data := a.newTemplateData(w, r)
 			data.Form = form
-			a.render(w, http.StatusUnprocessableEntity, "login.html", data)
+			a.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+			return
+		}
+		if errors.Is(err, models.ErrUnverified) {
+			form.AddNonFieldError("You need to verify your email address before you can log in. Follow the link we emailed you, or ask for a new one below.")
+			form.Unverified = true
+
+			data := a.newTemplateData(w, r)
+			data.Form = form
+			a.render(w, r, http.StatusForbidden, "login.html", data)
 			return
 		}
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
 
-	session, err := a.Store.New(r, "authsession")
+	// With two-factor authentication on, the password only gets the user as
+	// far as the second step.
+	_, err = a.twoFactor.Secret(r.Context(), id)
+	if err == nil {
+		err = a.loginThrottle.release(r.Context(), form.Email, ip)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		a.startSecondFactor(w, r, id, form.Email)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.loginThrottle.succeeded(r.Context(), form.Email, ip)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
 
-	session.Options.SameSite = http.SameSiteLaxMode
-	session.Values["userId"] = id
-	session.Save(r, w)
+	err = a.startAuthSession(w, r, id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
 
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
@@ -260,12 +542,364 @@ func (a *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 
 	authsession, err := a.Store.Get(r, "authsession")
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
+		return
+	}
+
+	authsession.Options.MaxAge = -1
+	authsession.Save(r, w)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// userLogoutAllPost signs the user out on every device by deleting all of
+// their sessions, this one included.
+func (a *application) userLogoutAllPost(w http.ResponseWriter, r *http.Request) {
+	_, err := a.sessions.DeleteForUser(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	authsession, err := a.Store.Get(r, "authsession")
+	if err != nil {
+		a.serverError(w, r, err)
 		return
 	}
 
 	authsession.Options.MaxAge = -1
 	authsession.Save(r, w)
 
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("You've been logged out on all your devices.")
+	session.Save(r, w)
+
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// sendVerificationEmail emails the user a link that proves they own their
+// address. Failing to send is only logged, since the user can ask for
+// another link.
+func (a *application) sendVerificationEmail(r *http.Request, user *models.User) error {
+	token, err := a.emailVerifications.Insert(r.Context(), user.ID, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	err = a.mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf(`Hi %s,
+
+Thanks for signing up to Snippetbox. To confirm that this is your email
+address, open this link within the next 24 hours:
+
+%s/user/verify/%s
+
+If you didn't sign up, you can ignore this email.
+`, user.Name, a.baseURL, token),
+	})
+	if err != nil {
+		a.logger.Error("sending verification email", "request_id", requestID(r), "error", err)
+	}
+
+	return nil
+}
+
+func (a *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	_, err := a.emailVerifications.Consume(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			session.AddFlash("That verification link is invalid or has expired. Log in to ask for a new one.")
+			session.Save(r, w)
+
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
+			session.AddFlash("That email address is already in use by another account.")
+			session.Save(r, w)
+
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	session.AddFlash("Your email address has been verified.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userVerifyResendPost sends a fresh verification link. Like the forgotten
+// password form, it answers the same way whatever the address.
+func (a *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	var form verificationResendForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := a.users.GetByEmail(r.Context(), form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if user != nil && !user.Verified {
+		err = a.sendVerificationEmail(r, user)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("If that address still needs verifying, we've emailed it a new link.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (a *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := a.newTemplateData(w, r)
+	data.Form = passwordForgotForm{}
+	a.render(w, r, http.StatusOK, "forgot.html", data)
+}
+
+// userPasswordForgotPost emails a reset link if the address belongs to an
+// account. The response is the same either way, so that it can't be used to
+// find out who has an account.
+func (a *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordForgotForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "forgot.html", data)
+		return
+	}
+
+	user, err := a.users.GetByEmail(r.Context(), form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if user != nil {
+		token, err := a.passwordResets.Insert(r.Context(), user.ID, passwordResetTTL)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		err = a.mailer.Send(r.Context(), mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Snippetbox password",
+			Body: fmt.Sprintf(`Hi %s,
+
+Someone, hopefully you, asked to reset the password for your Snippetbox
+account. To choose a new password, open this link within the next hour:
+
+%s/user/password/reset/%s
+
+If you didn't ask for this, you can ignore this email and your password
+will stay the same.
+`, user.Name, a.baseURL, token),
+		})
+		if err != nil {
+			a.logger.Error("sending password reset email", "request_id", requestID(r), "error", err)
+		}
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("If an account uses that address, we've emailed it a link to reset the password.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// invalidResetLink sends the user back to request a new link.
+func (a *application) invalidResetLink(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("That password reset link is invalid or has expired. Please request a new one.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+}
+
+func (a *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	_, err := a.passwordResets.Lookup(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			a.invalidResetLink(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Form = passwordResetForm{}
+	a.render(w, r, http.StatusOK, "reset.html", data)
+}
+
+// userPasswordResetPost sets the new password, uses up the token and signs
+// the user out everywhere, in case the old password was compromised.
+func (a *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	var form passwordResetForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(form.Confirm == form.Password, "confirm", "The passwords do not match")
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
+		return
+	}
+
+	userID, err := a.passwordResets.Consume(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			a.invalidResetLink(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = a.users.UpdatePassword(r.Context(), userID, form.Password)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	_, err = a.sessions.DeleteForUser(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Your password has been reset. Please log in.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (a *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := a.tokens.List(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Tokens = tokens
+	data.Form = tokenCreateForm{}
+
+	a.render(w, r, http.StatusOK, "tokens.html", data)
+}
+
+func (a *application) accountTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+
+	userID := a.authenticatedUserID(r)
+
+	if form.Valid() {
+		token, err := a.tokens.Insert(r.Context(), userID, form.Name)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		// The plaintext token is never stored, so rather than redirecting we
+		// render it straight away; this is the only time the user will see it.
+		tokens, err := a.tokens.List(r.Context(), userID)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		data := a.newTemplateData(w, r)
+		data.Tokens = tokens
+		data.NewToken = token
+		data.Form = tokenCreateForm{}
+		a.render(w, r, http.StatusOK, "tokens.html", data)
+		return
+	}
+
+	tokens, err := a.tokens.List(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Tokens = tokens
+	data.Form = form
+	a.render(w, r, http.StatusUnprocessableEntity, "tokens.html", data)
+}
+
+func (a *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := readIDParam(r)
+	if !ok {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := a.tokens.Revoke(r.Context(), a.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash("Token successfully revoked!")
+	err = session.Save(r, w)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
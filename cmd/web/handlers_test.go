@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models"
+	"snippetbox.mabona3.net/internal/models/mocks"
 )
 
 func TestPing(t *testing.T) {
@@ -27,6 +36,118 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, body, "OK")
 }
 
+func TestHome(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Default page",
+			urlPath:  "/",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "First page",
+			urlPath:  "/?page=1",
+			wantCode: http.StatusOK,
+			wantBody: "Page 1 of 1",
+		},
+		{
+			name:     "Past the last page",
+			urlPath:  "/?page=2",
+			wantCode: http.StatusOK,
+			wantBody: "nothing to see here",
+		},
+		{
+			name:     "Zero page",
+			urlPath:  "/?page=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "String page",
+			urlPath:  "/?page=foo",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Huge page",
+			urlPath:  "/?page=1000000000000000000",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetSearch(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Match",
+			urlPath:  "/snippet/search?q=pond",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent <mark>pond</mark>",
+		},
+		{
+			name:     "No match",
+			urlPath:  "/snippet/search?q=nginx",
+			wantCode: http.StatusOK,
+			wantBody: "No snippets matched your search.",
+		},
+		{
+			name:     "Empty query",
+			urlPath:  "/snippet/search",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/snippet/search?q=pond&page=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Huge page",
+			urlPath:  "/snippet/search?q=pond&page=1000000000000000000",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
 func TestSnippetView(t *testing.T) {
 	a := newTestApplication(t)
 	ts := newTestServer(t, a.routes())
@@ -45,6 +166,18 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name: "Valid ID author",
+			urlPath: "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "<span>By Alice</span>",
+		},
+		{
+			name: "Other user's snippet",
+			urlPath: "/snippet/view/3",
+			wantCode: http.StatusOK,
+			wantBody: "<span>By Bob</span>",
+		},
 		{
 			name: "Non-existent ID",
 			urlPath: "/snippet/view/2",
@@ -85,6 +218,45 @@ func TestSnippetView(t *testing.T) {
 	}
 }
 
+// recordingSnippetModel remembers who each inserted snippet belongs to.
+type recordingSnippetModel struct {
+	mocks.SnippetModel
+	insertedBy []int
+}
+
+func (m *recordingSnippetModel) Insert(ctx context.Context, userID int, title, content, language string, expires int) (int, error) {
+	m.insertedBy = append(m.insertedBy, userID)
+	return m.SnippetModel.Insert(ctx, userID, title, content, language, expires)
+}
+
+func TestSnippetCreatePostOwner(t *testing.T) {
+	snippets := &recordingSnippetModel{}
+
+	a := newTestApplication(t)
+	a.snippets = snippets
+
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/create")
+
+	form := url.Values{}
+	form.Add("title", "O snail")
+	form.Add("content", "O snail, climb Mount Fuji")
+	form.Add("language", "plaintext")
+	form.Add("expires", "7")
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, header, _ := ts.postForm(t, "/snippet/create", form)
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+	assert.Equal(t, len(snippets.insertedBy), 1)
+	assert.Equal(t, snippets.insertedBy[0], 1)
+}
+
 func TestUserSignup(t *testing.T) {
 	a := newTestApplication(t)
 	ts := newTestServer(t, a.routes())
@@ -199,3 +371,379 @@ func TestUserSignup(t *testing.T) {
 	}
 
 }
+
+func TestSnippetRaw(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/snippet/raw/1")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "An old silent pond...")
+	assert.Equal(t, header.Get("Content-Type"), "text/plain; charset=utf-8")
+
+	etag := header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag header set")
+	}
+
+	r, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/raw/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("If-None-Match", etag)
+
+	code, _, _ = ts.do(t, r)
+	assert.Equal(t, code, http.StatusNotModified)
+
+	// A snippet that has never been edited was last modified when it was
+	// created.
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Since(lastModified) < time.Minute, true)
+
+	r.Header.Del("If-None-Match")
+	r.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+
+	code, _, _ = ts.do(t, r)
+	assert.Equal(t, code, http.StatusNotModified)
+
+	r.Header.Set("If-Modified-Since", lastModified.Add(-time.Second).Format(http.TimeFormat))
+
+	code, _, _ = ts.do(t, r)
+	assert.Equal(t, code, http.StatusOK)
+
+	_, header, _ = ts.get(t, "/snippet/raw/3")
+	assert.Equal(t, header.Get("Last-Modified"), "Wed, 03 Jan 2024 04:05:06 GMT")
+
+	code, _, _ = ts.get(t, "/snippet/raw/2")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestSnippetDownload(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/snippet/download/1")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "An old silent pond...")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename=an-old-silent-pond.txt`)
+
+	code, _, _ = ts.get(t, "/snippet/download/2")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestSnippetEdit(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/snippet/edit/1")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Owner",
+			urlPath:  "/snippet/edit/1",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond...",
+		},
+		{
+			name:     "Not owner",
+			urlPath:  "/snippet/edit/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/edit/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/snippet/edit/foo",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/snippet/edit/1")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	postTests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		content  string
+		language string
+		wantCode int
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  "/snippet/edit/1",
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond",
+			language: "plaintext",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Empty title",
+			urlPath:  "/snippet/edit/1",
+			title:    "",
+			content:  "A frog jumps into the pond",
+			language: "plaintext",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Unknown language",
+			urlPath:  "/snippet/edit/1",
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond",
+			language: "klingon",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Not owner",
+			urlPath:  "/snippet/edit/3",
+			title:    "Over the wintry forest",
+			content:  "Winds howl in rage",
+			language: "plaintext",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range postTests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("language", tt.language)
+			form.Add("gorilla.csrf.Token", validCSRFToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestSnippetDelete(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Owner",
+			urlPath:  "/snippet/delete/1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Not owner",
+			urlPath:  "/snippet/delete/3",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/delete/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("gorilla.csrf.Token", validCSRFToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAccountTokens(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<td>CI</td>")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		tokenName string
+		wantCode  int
+		wantBody  string
+	}{
+		{
+			name:      "Create",
+			urlPath:   "/account/tokens",
+			tokenName: "Deploy script",
+			wantCode:  http.StatusOK,
+			wantBody:  mocks.MockToken,
+		},
+		{
+			name:      "Create with empty name",
+			urlPath:   "/account/tokens",
+			tokenName: "",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field cannot be blank",
+		},
+		{
+			name:     "Revoke",
+			urlPath:  "/account/tokens/revoke/1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Revoke someone else's token",
+			urlPath:  "/account/tokens/revoke/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokenName)
+			form.Add("gorilla.csrf.Token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// slowSnippetModel behaves as though every lookup hit the query timeout.
+type slowSnippetModel struct {
+	mocks.SnippetModel
+}
+
+func (m *slowSnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
+	return nil, fmt.Errorf("get snippet: %w", context.DeadlineExceeded)
+}
+
+func TestDatabaseTimeout(t *testing.T) {
+	a := newTestApplication(t)
+	a.snippets = &slowSnippetModel{}
+
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantBody string
+	}{
+		{
+			name:     "HTML page",
+			urlPath:  "/snippet/view/1",
+			wantBody: "Service Unavailable",
+		},
+		{
+			name:     "JSON API",
+			urlPath:  "/api/v1/snippets/1",
+			wantBody: `"message":"the server is temporarily unable`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, http.StatusServiceUnavailable)
+			assert.Equal(t, header.Get("Retry-After"), "5")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+// canceledSnippetModel behaves as though the client hung up mid-query.
+type canceledSnippetModel struct {
+	mocks.SnippetModel
+}
+
+func (m *canceledSnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
+	return nil, fmt.Errorf("get snippet: %w", context.Canceled)
+}
+
+func TestClientGone(t *testing.T) {
+	tests := []struct {
+		name    string
+		urlPath string
+	}{
+		{
+			name:    "HTML page",
+			urlPath: "/snippet/view/1",
+		},
+		{
+			name:    "JSON API",
+			urlPath: "/api/v1/snippets/1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			a := newTestApplication(t)
+			a.snippets = &canceledSnippetModel{}
+			a.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+			rr := httptest.NewRecorder()
+			a.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.urlPath, nil))
+
+			assert.Equal(t, rr.Code != http.StatusInternalServerError, true)
+			assert.Equal(t, rr.Body.Len(), 0)
+			assert.StringContains(t, logs.String(), "level=INFO msg=\"get snippet: context canceled\"")
+			assert.Equal(t, strings.Contains(logs.String(), "level=ERROR"), false)
+			assert.Equal(t, strings.Contains(logs.String(), "trace="), false)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// pinger is the part of *sql.DB that the readiness check needs.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// health holds the state behind the /healthz and /readyz endpoints.
+type health struct {
+	db         pinger
+	timeout    time.Duration
+	drainDelay time.Duration
+	draining   atomic.Bool
+}
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+const (
+	checkOK      = "ok"
+	checkFailing = "failing"
+)
+
+// drain marks the application as no longer ready, then waits drainDelay so
+// that load balancers polling /readyz stop sending traffic before the
+// listener is closed.
+func (h *health) drain() {
+	h.draining.Store(true)
+	time.Sleep(h.drainDelay)
+}
+
+// healthz reports whether the process is alive. It deliberately leaves out
+// the database, since restarting the process would not fix an outage there.
+func (a *application) healthz(w http.ResponseWriter, r *http.Request) {
+	a.writeHealth(w, map[string]healthCheck{
+		"templates": a.checkTemplates(),
+	})
+}
+
+// readyz reports whether the application can serve traffic: the database
+// answers within the timeout, the templates are loaded and the server is not
+// shutting down.
+func (a *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{
+		"database":  a.checkDatabase(r),
+		"templates": a.checkTemplates(),
+		"shutdown":  {Status: checkOK},
+	}
+
+	if a.health.draining.Load() {
+		checks["shutdown"] = healthCheck{Status: checkFailing, Detail: "server is shutting down"}
+	}
+
+	a.writeHealth(w, checks)
+}
+
+// checkDatabase pings the database. The error is logged rather than shown,
+// since /readyz is public and driver errors can name hosts and users.
+func (a *application) checkDatabase(r *http.Request) healthCheck {
+	ctx, cancel := context.WithTimeout(r.Context(), a.health.timeout)
+	defer cancel()
+
+	err := a.health.db.PingContext(ctx)
+	if err != nil {
+		a.logger.Warn("database health check", "request_id", requestID(r), "error", err)
+		return healthCheck{Status: checkFailing, Detail: "unavailable"}
+	}
+
+	return healthCheck{Status: checkOK}
+}
+
+func (a *application) checkTemplates() healthCheck {
+	if len(a.templateCache) == 0 {
+		return healthCheck{Status: checkFailing, Detail: "no templates loaded"}
+	}
+
+	return healthCheck{Status: checkOK, Detail: fmt.Sprintf("%d pages cached", len(a.templateCache))}
+}
+
+// writeHealth responds 200 if every check passed and 503 otherwise, with the
+// per-component results in the body.
+func (a *application) writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	status, code := checkOK, http.StatusOK
+
+	for _, c := range checks {
+		if c.Status != checkOK {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	a.writeJSON(w, code, envelope{"status": status, "checks": checks}, headers)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	a := newTestApplication(t)
+	a.health.db = fakePinger{err: errors.New("connection refused")}
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, header.Get("Cache-Control"), "no-store")
+	assert.StringContains(t, body, `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		dbErr        error
+		draining     bool
+		wantCode     int
+		wantStatus   string
+		wantDatabase string
+		wantDetail   string
+		wantShutdown string
+	}{
+		{
+			name:         "Ready",
+			wantCode:     http.StatusOK,
+			wantStatus:   "ok",
+			wantDatabase: "ok",
+			wantShutdown: "ok",
+		},
+		{
+			name:         "Database down",
+			dbErr:        errors.New("dial tcp db.internal:3306: connection refused"),
+			wantCode:     http.StatusServiceUnavailable,
+			wantStatus:   "unavailable",
+			wantDatabase: "failing",
+			wantDetail:   "unavailable",
+			wantShutdown: "ok",
+		},
+		{
+			name:         "Shutting down",
+			draining:     true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantStatus:   "unavailable",
+			wantDatabase: "ok",
+			wantShutdown: "failing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			a.health.db = fakePinger{err: tt.dbErr}
+			a.health.draining.Store(tt.draining)
+
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+
+			var got struct {
+				Status string                 `json:"status"`
+				Checks map[string]healthCheck `json:"checks"`
+			}
+			err := json.Unmarshal([]byte(body), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, got.Status, tt.wantStatus)
+			assert.Equal(t, got.Checks["database"].Status, tt.wantDatabase)
+			assert.Equal(t, got.Checks["database"].Detail, tt.wantDetail)
+			assert.Equal(t, strings.Contains(body, "db.internal"), false)
+			assert.Equal(t, got.Checks["templates"].Status, "ok")
+			assert.Equal(t, got.Checks["shutdown"].Status, tt.wantShutdown)
+		})
+	}
+}
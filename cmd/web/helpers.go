@@ -2,22 +2,74 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"regexp"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/sessions"
+	"github.com/julienschmidt/httprouter"
+	"snippetbox.mabona3.net/internal/models"
 )
 
-func (a *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	a.errorLog.Output(2, trace)
+func (a *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		a.serviceUnavailable(w, r, err)
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		a.clientGone(r, err)
+		return
+	}
+
+	a.logger.Error(err.Error(),
+		"request_id", requestID(r),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"trace", string(debug.Stack()),
+	)
 
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// serviceUnavailable reports a database call that ran out of time. That is
+// usually load or an outage rather than a bug, so there's no stack trace and
+// the client is invited to retry.
+func (a *application) serviceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.Warn(err.Error(),
+		"request_id", requestID(r),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+	)
+
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+// clientGone notes a request that was abandoned by the client, which cancels
+// its context. Nobody is left to read a response, so none is written, and
+// it's logged without a stack trace since nothing went wrong on our side.
+func (a *application) clientGone(r *http.Request, err error) {
+	a.logger.Info(err.Error(),
+		"request_id", requestID(r),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+	)
+}
+
 func (a *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
@@ -26,11 +78,12 @@ func (a *application) notFound(w http.ResponseWriter) {
 	a.clientError(w, http.StatusNotFound)
 }
 
-func (a application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (a application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 	ts, ok := a.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		a.serverError(w, err)
+		a.metrics.templateErrors.WithLabelValues(page).Inc()
+		a.serverError(w, r, err)
 		return
 	}
 
@@ -38,7 +91,8 @@ func (a application) render(w http.ResponseWriter, status int, page string, data
 
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		a.serverError(w, err)
+		a.metrics.templateErrors.WithLabelValues(page).Inc()
+		a.serverError(w, r, err)
 		return
 	}
 
@@ -60,7 +114,7 @@ func (a *application) decodePostForm(r *http.Request, dst any) error {
 			panic(err)
 		}
 
-		a.errorLog.Println(err)
+		a.logger.Warn("decoding form", "request_id", requestID(r), "error", err)
 		return err
 	}
 	return nil
@@ -79,10 +133,13 @@ func (a application) newTemplateData(w http.ResponseWriter, r *http.Request) *te
 	session.Save(r, w)
 
 	return &templateData{
-		CurrentYear:     time.Now().Year(),
-		Flash:           flashMsg,
-		IsAuthenticated: a.isAuthenticated(r),
-		CSRFField:       csrf.TemplateField(r),
+		CurrentYear:         time.Now().Year(),
+		Flash:               flashMsg,
+		IsAuthenticated:     a.isAuthenticated(r),
+		AuthenticatedUserID: a.authenticatedUserID(r),
+		OIDCEnabled:         a.oidc != nil,
+		CSRFField:           csrf.TemplateField(r),
+		Languages:           snippetLanguages,
 	}
 }
 
@@ -94,3 +151,213 @@ func (a *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 }
+
+// withAuthenticatedUser marks the request as coming from the given user,
+// however they proved who they are.
+func (a *application) withAuthenticatedUser(r *http.Request, id int) *http.Request {
+	ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+	ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+// clientIP returns the address of the client that sent r. When it arrives
+// through one of the trusted proxies, the address is read from
+// X-Forwarded-For instead: walking back from the end of the header, each
+// trusted proxy vouches for the hop before it, and the first untrusted one
+// is the client.
+func (a *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !a.isTrustedProxy(addr) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		host = hop.Unmap().String()
+		if !a.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return host
+}
+
+func (a *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range a.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for field := range strings.SplitSeq(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (a *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// maxPage is the highest page number accepted, far beyond any real listing
+// but low enough that the offset it gives can't overflow.
+const maxPage = 10000
+
+// readPage returns the value of the page query string parameter, defaulting
+// to 1. ok is false if the parameter is present but not an integer between
+// 1 and maxPage.
+func readPage(r *http.Request) (int, bool) {
+	p := r.URL.Query().Get("page")
+	if p == "" {
+		return 1, true
+	}
+
+	page, err := strconv.Atoi(p)
+	if err != nil || page < 1 || page > maxPage {
+		return 0, false
+	}
+
+	return page, true
+}
+
+// readIDParam returns the :id route parameter. ok is false if it is not a
+// positive integer.
+func readIDParam(r *http.Request) (int, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// readSnippet loads the snippet named by the :id route parameter. If it can't,
+// the appropriate error response has already been written and ok is false.
+func (a *application) readSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, ok := readIDParam(r)
+	if !ok {
+		a.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	snippet, err := a.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// snippetETag identifies a version of a snippet. Snippets can be edited after
+// they are created, so the content is hashed in along with the creation time.
+func snippetETag(s *models.Snippet) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00", s.ID, s.Created.UnixNano(), s.Language)
+	io.WriteString(h, s.Content)
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+var nonSlugRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// snippetFilename suggests a file name for downloading a snippet, made from
+// its title and an extension matching its language.
+func snippetFilename(s *models.Snippet) string {
+	name := strings.Trim(nonSlugRX.ReplaceAllString(strings.ToLower(s.Title), "-"), "-")
+	if name == "" {
+		name = fmt.Sprintf("snippet-%d", s.ID)
+	}
+
+	ext := ".txt"
+	if lexer := lexers.Get(s.Language); lexer != nil {
+		for _, pattern := range lexer.Config().Filenames {
+			if e, ok := strings.CutPrefix(pattern, "*"); ok && !strings.ContainsAny(e, "*?[") {
+				ext = e
+				break
+			}
+		}
+	}
+
+	return name + ext
+}
+
+// ownedSnippet loads the snippet named by the :id route parameter and checks
+// that it belongs to the authenticated user. If it doesn't, the appropriate
+// error response has already been written and ok is false.
+func (a *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, ok := a.readSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if snippet.UserID != a.authenticatedUserID(r) {
+		a.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return snippet, true
+}
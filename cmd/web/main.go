@@ -1,61 +1,238 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/schema"
-	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	_ "modernc.org/sqlite"
+	"snippetbox.mabona3.net/internal/mailer"
 	"snippetbox.mabona3.net/internal/models"
 )
 
 type application struct {
-	infoLog       *log.Logger
-	errorLog      *log.Logger
-	snippets      models.SnippetModelInterface
-	users         models.UserModelInterface
-	templateCache map[string]*template.Template
-	formDecoder   *schema.Decoder
-	Store         *sessions.CookieStore
+	logger             *slog.Logger
+	snippets           models.SnippetModelInterface
+	users              models.UserModelInterface
+	tokens             models.TokenModelInterface
+	sessions           models.SessionModelInterface
+	passwordResets     models.PasswordResetModelInterface
+	emailVerifications models.EmailVerificationModelInterface
+	twoFactor          models.TwoFactorModelInterface
+	identities         models.IdentityModelInterface
+	oidc               *oidcProvider
+	loginThrottle      *loginThrottle
+	readLimiter        *rateLimiter
+	writeLimiter       *rateLimiter
+	trustedProxies     []netip.Prefix
+	templateCache      map[string]*template.Template
+	formDecoder        *schema.Decoder
+	mailer             mailer.Mailer
+	baseURL            string
+	Store              *sessionStore
+	metrics            *metrics
+	health             *health
+
+	sessionIdleTimeout time.Duration
+	sessionLifetime    time.Duration
+}
+
+type config struct {
+	secretKey   string
+	addr        string
+	baseURL     string
+	metricsAddr string
+	db          struct {
+		driver string
+		dsn    string
+	}
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	log             struct {
+		format string
+		level  slog.Level
+	}
+	reaper struct {
+		interval  time.Duration
+		batchSize int
+	}
+	session struct {
+		idleTimeout time.Duration
+		lifetime    time.Duration
+	}
+	throttleStore string
+	rateLimit     struct {
+		enabled bool
+		read    rateLimitConfig
+		write   rateLimitConfig
+	}
+	trustedProxies string
+	oidc           struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+	}
+	smtp struct {
+		addr     string
+		username string
+		password string
+		from     string
+	}
+}
+
+type rateLimitConfig struct {
+	rps   float64
+	burst int
 }
 
 func main() {
-	var addr string
-	var dsn string
-	var store *sessions.CookieStore
-	getVars(&dsn, &addr, &store)
+	var cfg config
+	getVars(&cfg)
+
+	logger, err := newLogger(os.Stdout, cfg.log.format, cfg.log.level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		err = migrate(cfg, flag.Args()[1:], os.Stdout)
+		if errors.Is(err, errMigrateUsage) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		} else if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = run(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// run is main without the process exit, so that deferred cleanup such as
+// closing the database pool always happens.
+func run(cfg config, logger *slog.Logger) error {
+	dialect, err := models.DialectFor(cfg.db.driver)
+	if err != nil {
+		return err
+	}
 
-	errLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// A zero interval would panic in time.NewTicker, and a batch size below
+	// 1 would never finish a round of reaping.
+	if cfg.reaper.interval <= 0 || cfg.reaper.batchSize < 1 {
+		return errors.New("-reap-interval and -reap-batch-size must be positive")
+	}
+
+	// Otherwise every session would expire as soon as it started.
+	if cfg.session.idleTimeout <= 0 || cfg.session.lifetime <= 0 {
+		return errors.New("-session-idle-timeout and -session-lifetime must be positive")
+	}
 
-	db, err := openDB(dsn)
+	db, err := openDB(cfg.db.driver, cfg.db.dsn)
 	if err != nil {
-		errLog.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
 	newtemplateCache, err := newTemplateCache()
 	if err != nil {
-		errLog.Fatal(err)
+		return err
 	}
 
 	formDecoder := schema.NewDecoder()
 
+	sessionModel := &models.SessionModel{DB: db, Dialect: dialect}
+
+	trustedProxies, err := parseTrustedProxies(cfg.trustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid -trusted-proxies: %w", err)
+	}
+
+	var readLimiter, writeLimiter *rateLimiter
+
+	if cfg.rateLimit.enabled {
+		for _, limit := range []rateLimitConfig{cfg.rateLimit.read, cfg.rateLimit.write} {
+			if limit.rps <= 0 || limit.burst < 1 {
+				return errors.New("rate limits need a positive rate and a burst of at least 1")
+			}
+		}
+
+		readLimiter = newRateLimiter(cfg.rateLimit.read.rps, cfg.rateLimit.read.burst)
+		writeLimiter = newRateLimiter(cfg.rateLimit.write.rps, cfg.rateLimit.write.burst)
+	}
+
+	var loginAttempts models.LoginAttemptModelInterface
+
+	switch cfg.throttleStore {
+	case "memory":
+		loginAttempts = newMemoryLoginAttempts()
+	case "db":
+		loginAttempts = &models.LoginAttemptModel{DB: db, Dialect: dialect}
+	default:
+		return fmt.Errorf("unknown login throttle store %q", cfg.throttleStore)
+	}
+
+	var sso *oidcProvider
+
+	if cfg.oidc.issuer != "" {
+		sso, err = newOIDCProvider(context.Background(), cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret,
+			cfg.baseURL+"/user/login/oidc/callback")
+		if err != nil {
+			return fmt.Errorf("setting up single sign-on: %w", err)
+		}
+	}
+
+	m := newMetrics()
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+
 	a := application{
-		infoLog:       log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime),
-		errorLog:      errLog,
-		snippets:      &models.SnippetModel{DB: db},
-		users:         &models.UserModel{DB: db},
-		templateCache: newtemplateCache,
-		formDecoder:   formDecoder,
-		Store:         store,
+		logger:             logger,
+		snippets:           &models.SnippetModel{DB: db, Dialect: dialect},
+		users:              &countingUserModel{&models.UserModel{DB: db, Dialect: dialect}, m.authentications},
+		tokens:             &models.TokenModel{DB: db, Dialect: dialect},
+		sessions:           sessionModel,
+		passwordResets:     &models.PasswordResetModel{DB: db, Dialect: dialect},
+		emailVerifications: &models.EmailVerificationModel{DB: db, Dialect: dialect},
+		twoFactor:          &models.TwoFactorModel{DB: db, Dialect: dialect},
+		identities:         &models.IdentityModel{DB: db, Dialect: dialect},
+		oidc:               sso,
+		loginThrottle:      newLoginThrottle(loginAttempts),
+		readLimiter:        readLimiter,
+		writeLimiter:       writeLimiter,
+		trustedProxies:     trustedProxies,
+		mailer:             newMailer(cfg, logger),
+		baseURL:            cfg.baseURL,
+		templateCache:      newtemplateCache,
+		formDecoder:        formDecoder,
+		Store:              newSessionStore(sessionModel, []byte(cfg.secretKey)),
+		metrics:            m,
+		health: &health{
+			db:         db,
+			timeout:    2 * time.Second,
+			drainDelay: cfg.drainDelay,
+		},
+
+		sessionIdleTimeout: cfg.session.idleTimeout,
+		sessionLifetime:    cfg.session.lifetime,
 	}
 
 	tlsConfig := &tls.Config{
@@ -63,8 +240,8 @@ func main() {
 	}
 
 	srv := &http.Server{
-		Addr:         addr,
-		ErrorLog:     a.errorLog,
+		Addr:         cfg.addr,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:      a.routes(),
 		TLSConfig:    tlsConfig,
 		IdleTimeout:  time.Minute,
@@ -72,23 +249,99 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	a.infoLog.Printf("Starting server on %s", addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	a.errorLog.Fatal(err)
+	tasks := []func(context.Context){}
+
+	reaped := map[string]expiredDeleter{
+		"snippets":            a.snippets,
+		"sessions":            a.sessions,
+		"password resets":     a.passwordResets,
+		"email verifications": a.emailVerifications,
+		"login attempts":      loginAttempts,
+	}
+
+	if cfg.rateLimit.enabled {
+		reaped["read rate limits"] = readLimiter
+		reaped["write rate limits"] = writeLimiter
+	}
+
+	for kind, store := range reaped {
+		rp := &reaper{
+			kind:      kind,
+			store:     store,
+			logger:    logger,
+			interval:  cfg.reaper.interval,
+			batchSize: cfg.reaper.batchSize,
+			now:       time.Now,
+		}
+		tasks = append(tasks, rp.run)
+	}
+
+	if cfg.metricsAddr != "" {
+		tasks = append(tasks, func(ctx context.Context) {
+			a.serveMetrics(ctx, cfg.metricsAddr)
+		})
+	}
+
+	return a.serve(srv, cfg.shutdownTimeout, tasks...)
 }
 
-func getVars(dsn *string, addr *string, store **sessions.CookieStore) {
+func getVars(cfg *config) {
 	godotenv.Load(".env")
 
-	*store = sessions.NewCookieStore([]byte(os.Getenv("SECRET_KEY")))
+	cfg.secretKey = os.Getenv("SECRET_KEY")
 
-	*addr = *flag.String("addr", ":"+os.Getenv("PORT"), "HTTP network address")
-	*dsn = *flag.String("dsn", os.Getenv("DSN"), "MySQL data source name")
+	flag.StringVar(&cfg.addr, "addr", ":"+os.Getenv("PORT"), "HTTP network address")
+	flag.StringVar(&cfg.baseURL, "base-url", "", "Public URL of the site, used in links sent by email (default https://localhost plus -addr)")
+	flag.StringVar(&cfg.metricsAddr, "metrics-addr", "localhost:9090", "Network address for the Prometheus metrics endpoint (empty to disable)")
+	flag.StringVar(&cfg.db.driver, "db-driver", "mysql", "Database driver (mysql|sqlite)")
+	flag.StringVar(&cfg.db.dsn, "dsn", os.Getenv("DSN"), "Data source name for the database driver")
+	flag.StringVar(&cfg.log.format, "log-format", "text", "Log output format (text|json)")
+	flag.TextVar(&cfg.log.level, "log-level", slog.LevelInfo, "Minimum log level (debug|info|warn|error)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 20*time.Second, "How long to wait for in-flight requests when shutting down")
+	flag.DurationVar(&cfg.drainDelay, "drain-delay", 0, "How long /readyz reports failure before the server stops accepting connections on shutdown")
+	flag.DurationVar(&cfg.session.idleTimeout, "session-idle-timeout", 30*time.Minute, "Sign users out after this long without a request")
+	flag.DurationVar(&cfg.session.lifetime, "session-lifetime", 12*time.Hour, "Sign users out this long after they log in, however active")
+	flag.StringVar(&cfg.throttleStore, "login-throttle-store", "memory", "Where failed logins are counted (memory|db); use db when running several instances")
+	flag.BoolVar(&cfg.rateLimit.enabled, "rate-limit", true, "Rate limit requests per user, or per IP address when signed out")
+	flag.Float64Var(&cfg.rateLimit.read.rps, "rate-limit-read-rps", 10, "Sustained requests per second allowed for pages and API reads")
+	flag.IntVar(&cfg.rateLimit.read.burst, "rate-limit-read-burst", 40, "Requests allowed in a burst for pages and API reads")
+	flag.Float64Var(&cfg.rateLimit.write.rps, "rate-limit-write-rps", 0.5, "Sustained requests per second allowed for form submissions and API writes")
+	flag.IntVar(&cfg.rateLimit.write.burst, "rate-limit-write-burst", 10, "Requests allowed in a burst for form submissions and API writes")
+	flag.StringVar(&cfg.trustedProxies, "trusted-proxies", "", "Comma-separated IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted")
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "Issuer URL of an OpenID Connect provider to offer single sign-on with (empty to disable)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "Client ID registered with the OpenID Connect provider")
+	flag.StringVar(&cfg.smtp.addr, "smtp-addr", "", "SMTP relay host:port (empty to log emails instead of sending them)")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.from, "smtp-from", "Snippetbox <no-reply@snippetbox.mabona3.net>", "Sender address for emails")
+	flag.DurationVar(&cfg.reaper.interval, "reap-interval", time.Hour, "How often to purge expired records, such as snippets, sessions and one-time tokens")
+	flag.IntVar(&cfg.reaper.batchSize, "reap-batch-size", 1000, "Maximum number of expired records to delete per statement")
 	flag.Parse()
+
+	cfg.smtp.password = os.Getenv("SMTP_PASSWORD")
+	cfg.oidc.clientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+
+	if cfg.baseURL == "" {
+		cfg.baseURL = "https://localhost" + cfg.addr
+	}
+}
+
+// newMailer returns an SMTP mailer if a relay is configured, and otherwise
+// one that only logs messages.
+func newMailer(cfg config, logger *slog.Logger) mailer.Mailer {
+	if cfg.smtp.addr == "" {
+		return &mailer.Log{Logger: logger}
+	}
+
+	return &mailer.SMTP{
+		Addr:     cfg.smtp.addr,
+		Username: cfg.smtp.username,
+		Password: cfg.smtp.password,
+		From:     cfg.smtp.from,
+	}
 }
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+func openDB(driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -97,3 +350,16 @@ func openDB(dsn string) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
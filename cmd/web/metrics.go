@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"snippetbox.mabona3.net/internal/models"
+)
+
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	templateErrors  *prometheus.CounterVec
+	authentications *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "HTTP requests handled, by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		templateErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_template_render_errors_total",
+			Help: "Failures to render an HTML page, by page template.",
+		}, []string{"page"}),
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_password_authentications_total",
+			Help: "Password (bcrypt) checks performed, by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.templateErrors,
+		m.authentications,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// instrument records request counts and latencies for h under route, the
+// router pattern (e.g. /snippet/view/:id) rather than the raw path, which
+// keeps the number of label values bounded.
+func (m *metrics) instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			status := sr.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+			m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}()
+
+		h.ServeHTTP(sr, r)
+	})
+}
+
+// instrumentedRouter registers routes on an httprouter.Router, wrapping each
+// handler with metrics.instrument under its route pattern.
+type instrumentedRouter struct {
+	*httprouter.Router
+	metrics *metrics
+}
+
+func (ir instrumentedRouter) Handler(method, path string, h http.Handler) {
+	ir.Router.Handler(method, path, ir.metrics.instrument(path, h))
+}
+
+func (ir instrumentedRouter) HandlerFunc(method, path string, h http.HandlerFunc) {
+	ir.Handler(method, path, h)
+}
+
+// countingUserModel counts the password checks made through Authenticate
+// and CheckPassword. Every other method is passed straight through to the
+// wrapped model.
+type countingUserModel struct {
+	models.UserModelInterface
+	counter *prometheus.CounterVec
+}
+
+func (m *countingUserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	id, err := m.UserModelInterface.Authenticate(ctx, email, password)
+	m.count(err)
+	return id, err
+}
+
+func (m *countingUserModel) CheckPassword(ctx context.Context, id int, password string) error {
+	err := m.UserModelInterface.CheckPassword(ctx, id, password)
+	m.count(err)
+	return err
+}
+
+// count records the result of a password check. A user that no longer
+// exists counts as invalid, as an unknown email address does.
+func (m *countingUserModel) count(err error) {
+	switch {
+	case err == nil:
+		m.counter.WithLabelValues("success").Inc()
+	case errors.Is(err, models.ErrInvalidCredentials), errors.Is(err, models.ErrNoRecord):
+		m.counter.WithLabelValues("invalid").Inc()
+	case errors.Is(err, models.ErrUnverified):
+		m.counter.WithLabelValues("unverified").Inc()
+	default:
+		m.counter.WithLabelValues("error").Inc()
+	}
+}
+
+// serveMetrics exposes the registry in the Prometheus text format on its own
+// listener, so it can be kept off the public interface, until ctx is
+// cancelled.
+func (a *application) serveMetrics(ctx context.Context, addr string) {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      promhttp.HandlerFor(a.metrics.registry, promhttp.HandlerOpts{}),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	a.logger.Info("starting metrics server", "addr", addr)
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		a.logger.Error("metrics server", "error", err)
+	}
+}
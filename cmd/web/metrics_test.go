@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+)
+
+func TestMetricsInstrument(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/snippet/view/1", "/snippet/view/3", "/snippet/view/2"} {
+		ts.get(t, path)
+	}
+
+	requests := a.metrics.requests
+
+	assert.Equal(t, testutil.ToFloat64(requests.WithLabelValues("/snippet/view/:id", "GET", "200")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(requests.WithLabelValues("/snippet/view/:id", "GET", "404")), 1.0)
+	assert.Equal(t, testutil.CollectAndCount(a.metrics.requestDuration), 1)
+}
+
+func TestCountingUserModel(t *testing.T) {
+	m := newMetrics()
+	users := &countingUserModel{&mocks.UserModel{}, m.authentications}
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantResult string
+	}{
+		{
+			name:       "Valid credentials",
+			email:      "alice@example.com",
+			password:   "pa$$word",
+			wantResult: "success",
+		},
+		{
+			name:       "Wrong password",
+			email:      "alice@example.com",
+			password:   "wrong",
+			wantResult: "invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(m.authentications.WithLabelValues(tt.wantResult))
+
+			users.Authenticate(t.Context(), tt.email, tt.password)
+
+			after := testutil.ToFloat64(m.authentications.WithLabelValues(tt.wantResult))
+			assert.Equal(t, after-before, 1.0)
+		})
+	}
+}
+
+func TestCountingUserModelCheckPassword(t *testing.T) {
+	m := newMetrics()
+	users := &countingUserModel{&mocks.UserModel{}, m.authentications}
+
+	tests := []struct {
+		name       string
+		id         int
+		password   string
+		wantResult string
+	}{
+		{
+			name:       "Valid password",
+			id:         1,
+			password:   "pa$$word",
+			wantResult: "success",
+		},
+		{
+			name:       "Wrong password",
+			id:         1,
+			password:   "wrong",
+			wantResult: "invalid",
+		},
+		{
+			name:       "Deleted user",
+			id:         99,
+			password:   "pa$$word",
+			wantResult: "invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(m.authentications.WithLabelValues(tt.wantResult))
+
+			users.CheckPassword(t.Context(), tt.id, tt.password)
+
+			after := testutil.ToFloat64(m.authentications.WithLabelValues(tt.wantResult))
+			assert.Equal(t, after-before, 1.0)
+		})
+	}
+}
+
+func TestMetricsNotFoundRoute(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/no/such/page")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	got := testutil.ToFloat64(a.metrics.requests.WithLabelValues("NotFound", "GET", "404"))
+	assert.Equal(t, got, 1.0)
+}
@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gorilla/csrf"
+	"snippetbox.mabona3.net/internal/models"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -20,10 +27,78 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
-func (a application) logRequest(next http.Handler) http.Handler {
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// assignRequestID gives every request an ID, reusing one set by a proxy in
+// front of us if it looks sane, so log lines can be correlated.
+func assignRequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder captures the status code and body size written by a
+// handler so they can be logged afterwards.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (a *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			status := sr.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			a.logger.Info("request",
+				"request_id", requestID(r),
+				"remote_addr", r.RemoteAddr,
+				"proto", r.Proto,
+				"method", r.Method,
+				"uri", r.URL.RequestURI(),
+				"status", status,
+				"bytes", sr.bytes,
+				"duration", time.Since(start),
+			)
+		}()
+
+		next.ServeHTTP(sr, r)
 	})
 }
 
@@ -32,7 +107,7 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
-				a.serverError(w, fmt.Errorf("%s", err))
+				a.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -47,7 +122,7 @@ func (a *application) InitializeSession(next http.Handler) http.Handler {
 		r = r.WithContext(ctx)
 
 		if err != nil {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 			return
 		}
 
@@ -67,6 +142,36 @@ func (a *application) noSurf(next http.Handler) http.Handler {
 
 func (a *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			// Tokens are for scripts working with snippets. On the account
+			// and login pages, a leaked token could mint more tokens or
+			// change the email address and so take over the account.
+			if !strings.HasPrefix(r.URL.Path, "/snippet/") {
+				a.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			id, err := a.tokens.Authenticate(r.Context(), token)
+			if err == nil {
+				id, err = a.existingUser(r, id)
+			}
+			if err != nil {
+				if errors.Is(err, models.ErrInvalidCredentials) {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					a.clientError(w, http.StatusUnauthorized)
+				} else {
+					a.serverError(w, r, err)
+				}
+				return
+			}
+
+			// Browsers never attach bearer tokens on their own, so a request
+			// carrying one can't have been forged cross-site.
+			r = csrf.UnsafeSkipCheck(r)
+			next.ServeHTTP(w, a.withAuthenticatedUser(r, id))
+			return
+		}
+
 		session, err := a.Store.Get(r, "authsession")
 		if err != nil {
 			next.ServeHTTP(w ,r)
@@ -78,15 +183,71 @@ func (a *application) authenticate(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		exists, err := a.users.Exists(id)
+
+		now := time.Now()
+
+		if a.sessionExpired(session, now) {
+			a.expireSession(w, r, session)
+			return
+		}
+
+		exists, err := a.users.Exists(r.Context(), id)
 		if err != nil {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 			return
 		}
 
 		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+			err = a.touchSession(w, r, session, now)
+			if errors.Is(err, errSessionRevoked) {
+				// The user signed out everywhere while this request was
+				// under way.
+				next.ServeHTTP(w, r)
+				return
+			} else if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
+
+			r = a.withAuthenticatedUser(r, id)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// existingUser returns ErrInvalidCredentials if the user a token belongs to
+// has been deleted. Deleting a user deletes their tokens too, but only where
+// the database enforces foreign keys.
+func (a *application) existingUser(r *http.Request, id int) (int, error) {
+	exists, err := a.users.Exists(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	return id, nil
+}
+
+// requireVerified stops users who haven't verified their email address,
+// showing them how to get a new link instead. It must come after
+// requireAuthentication.
+func (a *application) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.users.Get(r.Context(), a.authenticatedUserID(r))
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		if !user.Verified {
+			data := a.newTemplateData(w, r)
+			data.Form = verificationResendForm{Email: user.Email}
+			a.render(w, r, http.StatusForbidden, "unverified.html", data)
+			return
 		}
 
 		next.ServeHTTP(w, r)
@@ -113,3 +274,116 @@ func (a *application) requireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticateAPI is the API counterpart of authenticate. API clients don't
+// carry the session cookies (and so aren't covered by CSRF protection), so
+// they identify themselves on every request with a personal API token, or
+// with HTTP Basic credentials.
+func (a *application) authenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id int
+		var err error
+
+		if token, ok := bearerToken(r); ok {
+			id, err = a.tokens.Authenticate(r.Context(), token)
+			if err == nil {
+				id, err = a.existingUser(r, id)
+			}
+		} else if email, password, ok := r.BasicAuth(); ok {
+			// Basic credentials are a password check like the login form,
+			// so they share its throttling.
+			var wait time.Duration
+
+			ip := a.clientIP(r)
+
+			wait, err = a.loginThrottle.attempt(r.Context(), email, ip)
+			if err != nil {
+				a.apiServerError(w, r, err)
+				return
+			}
+
+			if wait > 0 {
+				setRetryAfter(w, wait)
+				a.apiClientError(w, http.StatusTooManyRequests, fmt.Sprintf("too many failed login attempts; try again in %s", retryMessage(wait)))
+				return
+			}
+
+			id, err = a.users.Authenticate(r.Context(), email, password)
+
+			// A password alone isn't enough for an account with two-factor
+			// authentication, which must use an API token instead.
+			twoFactor := false
+			if err == nil {
+				_, err = a.twoFactor.Secret(r.Context(), id)
+				if err == nil {
+					twoFactor = true
+				} else if errors.Is(err, models.ErrNoRecord) {
+					err = nil
+				}
+			}
+
+			// The attempt was counted as a failure, which only a wrong
+			// password should be.
+			var throttleErr error
+			if err == nil && !twoFactor {
+				throttleErr = a.loginThrottle.succeeded(r.Context(), email, ip)
+			} else if !errors.Is(err, models.ErrInvalidCredentials) {
+				throttleErr = a.loginThrottle.release(r.Context(), email, ip)
+			}
+			if throttleErr != nil {
+				a.apiServerError(w, r, throttleErr)
+				return
+			}
+
+			if twoFactor {
+				a.apiAuthenticationRequired(w, "accounts with two-factor authentication must use an API token")
+				return
+			}
+		} else {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				a.apiAuthenticationRequired(w, "invalid authentication credentials")
+			} else if errors.Is(err, models.ErrUnverified) {
+				a.apiClientError(w, http.StatusForbidden, "you must verify your email address first")
+			} else {
+				a.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, a.withAuthenticatedUser(r, id))
+	})
+}
+
+func (a *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.isAuthenticated(r) {
+			a.apiAuthenticationRequired(w, "you must be authenticated to access this resource")
+			return
+		}
+		w.Header().Add("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIVerified is the API counterpart of requireVerified.
+func (a *application) requireAPIVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.users.Get(r.Context(), a.authenticatedUserID(r))
+		if err != nil {
+			a.apiServerError(w, r, err)
+			return
+		}
+
+		if !user.Verified {
+			a.apiClientError(w, http.StatusForbidden, "you must verify your email address first")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -48,3 +51,156 @@ func TestSecureHeaders(t *testing.T) {
 	bytes.TrimSpace(body)
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{
+			name:     "Valid token",
+			path:     "/snippet/create",
+			token:    mocks.MockToken,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Invalid token",
+			path:     "/snippet/create",
+			token:    "sbx_wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Deleted user's token",
+			path:     "/snippet/create",
+			token:    mocks.MockDeletedUserToken,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "No token",
+			path:     "/snippet/create",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Account page",
+			path:     "/account/tokens",
+			token:    mocks.MockToken,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "User page",
+			path:     "/user/login/2fa",
+			token:    mocks.MockToken,
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			code, _, _ := ts.do(t, r)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAssignRequestID(t *testing.T) {
+	var gotID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = requestID(r)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		wantID string
+	}{
+		{
+			name:   "From proxy",
+			header: "abc-123",
+			wantID: "abc-123",
+		},
+		{
+			name: "Missing",
+		},
+		{
+			name:   "Invalid",
+			header: "abc\n123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+
+			assignRequestID(next).ServeHTTP(rr, r)
+
+			if tt.wantID != "" {
+				assert.Equal(t, gotID, tt.wantID)
+			} else {
+				assert.Equal(t, len(gotID), 32)
+			}
+			assert.Equal(t, rr.Result().Header.Get("X-Request-ID"), gotID)
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := newTestApplication(t)
+	a.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	r, err := http.NewRequest(http.MethodGet, "/pot?kind=tea", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Request-ID", "abc-123")
+
+	assignRequestID(a.logRequest(next)).ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		URI       string `json:"uri"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, entry.Msg, "request")
+	assert.Equal(t, entry.RequestID, "abc-123")
+	assert.Equal(t, entry.URI, "/pot?kind=tea")
+	assert.Equal(t, entry.Status, http.StatusTeapot)
+	assert.Equal(t, entry.Bytes, len("short and stout"))
+}
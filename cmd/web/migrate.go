@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"snippetbox.mabona3.net/internal/migrations"
+)
+
+var errMigrateUsage = errors.New("usage: snippetbox [flags] migrate up|down|status")
+
+// migrate implements the migrate subcommand, which applies, rolls back or
+// lists the schema migrations for the database given by -db-driver and -dsn.
+func migrate(cfg config, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		return errMigrateUsage
+	}
+
+	db, err := openDB(cfg.db.driver, cfg.db.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db, cfg.db.driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, migration := range applied {
+			fmt.Fprintf(w, "applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(w, "database is up to date")
+		}
+
+	case "down":
+		migration, err := m.Down()
+		if errors.Is(err, migrations.ErrNoChange) {
+			fmt.Fprintln(w, "nothing to roll back")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "rolled back %04d_%s\n", migration.Version, migration.Name)
+
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, migration := range status {
+			applied := "pending"
+			if migration.IsApplied() {
+				applied = migration.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", migration.Version, migration.Name, applied)
+		}
+		return tw.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	var cfg config
+	cfg.db.driver = "sqlite"
+	cfg.db.dsn = "file:" + filepath.Join(t.TempDir(), "test.db")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantOut string // a regular expression
+		wantErr error
+	}{
+		{
+			name:    "Status before",
+			args:    []string{"status"},
+			wantOut: `0001\s+create_users\s+pending`,
+		},
+		{
+			name:    "Up",
+			args:    []string{"up"},
+			wantOut: `applied 0001_create_users\n`,
+		},
+		{
+			name:    "Up again",
+			args:    []string{"up"},
+			wantOut: "database is up to date",
+		},
+		{
+			name:    "Down",
+			args:    []string{"down"},
+			wantOut: `rolled back \d{4}_\w+\n`,
+		},
+		{
+			name:    "Status after",
+			args:    []string{"status"},
+			wantOut: `0001\s+create_users\s+\d{4}-\d{2}-\d{2}`,
+		},
+		{
+			name:    "Unknown command",
+			args:    []string{"sideways"},
+			wantErr: errMigrateUsage,
+		},
+		{
+			name:    "Missing command",
+			wantErr: errMigrateUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := migrate(cfg, tt.args, &out)
+			if tt.wantErr != nil {
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !regexp.MustCompile(tt.wantOut).MatchString(out.String()) {
+				t.Errorf("got: %q; expected to match: %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+	"snippetbox.mabona3.net/internal/models"
+)
+
+// Session keys for a single sign-on login waiting on the provider.
+const (
+	oidcStateKey    = "oidcState"
+	oidcNonceKey    = "oidcNonce"
+	oidcVerifierKey = "oidcVerifier"
+	oidcAtKey       = "oidcAt"
+)
+
+// oidcLoginTimeout is how long the user has to log in at the provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcProvider is an OpenID Connect identity provider that users can log in
+// with instead of a password.
+type oidcProvider struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	client   *http.Client
+}
+
+// newOIDCProvider discovers the provider's endpoints and signing keys from
+// its issuer URL.
+func newOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*oidcProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcProvider{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		client:   client,
+	}, nil
+}
+
+// oidcClaims are the ID token claims used to find or create the user.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// userLoginOIDC sends the user to the provider to log in, using the
+// authorization code flow with PKCE.
+func (a *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	state := rand.Text()
+	nonce := rand.Text()
+	verifier := oauth2.GenerateVerifier()
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.Values[oidcStateKey] = state
+	session.Values[oidcNonceKey] = nonce
+	session.Values[oidcVerifierKey] = verifier
+	session.Values[oidcAtKey] = time.Now().Unix()
+	session.Save(r, w)
+
+	url := a.oidc.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// oidcLoginFailed sends the user back to the login page with a message.
+func (a *application) oidcLoginFailed(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if err != nil {
+		a.logger.Warn("single sign-on login", "request_id", requestID(r), "error", err)
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.AddFlash(message)
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userLoginOIDCCallback is where the provider sends the user back to. The
+// ID token it issues is checked, and the user it identifies is signed in,
+// after linking or creating an account by their verified email address.
+func (a *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	state, _ := session.Values[oidcStateKey].(string)
+	nonce, _ := session.Values[oidcNonceKey].(string)
+	verifier, _ := session.Values[oidcVerifierKey].(string)
+	at, _ := session.Values[oidcAtKey].(int64)
+
+	// Each login attempt can only come back once.
+	delete(session.Values, oidcStateKey)
+	delete(session.Values, oidcNonceKey)
+	delete(session.Values, oidcVerifierKey)
+	delete(session.Values, oidcAtKey)
+	session.Save(r, w)
+
+	query := r.URL.Query()
+
+	if state == "" || time.Since(time.Unix(at, 0)) > oidcLoginTimeout ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		a.oidcLoginFailed(w, r, "Your single sign-on login timed out. Please try again.", nil)
+		return
+	}
+
+	if e := query.Get("error"); e != "" {
+		a.oidcLoginFailed(w, r, "Single sign-on login was cancelled or refused.",
+			fmt.Errorf("provider returned %s: %s", e, query.Get("error_description")))
+		return
+	}
+
+	ctx := oidc.ClientContext(r.Context(), a.oidc.client)
+
+	token, err := a.oidc.config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		a.oidcLoginFailed(w, r, "We couldn't complete your single sign-on login. Please try again.", err)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		a.oidcLoginFailed(w, r, "We couldn't complete your single sign-on login. Please try again.",
+			errors.New("token response has no id_token"))
+		return
+	}
+
+	idToken, err := a.oidc.verifier.Verify(ctx, rawIDToken)
+	if err == nil && subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		err = errors.New("ID token nonce doesn't match")
+	}
+	if err != nil {
+		a.oidcLoginFailed(w, r, "We couldn't complete your single sign-on login. Please try again.", err)
+		return
+	}
+
+	var claims oidcClaims
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		a.oidcLoginFailed(w, r, "We couldn't complete your single sign-on login. Please try again.", err)
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		a.oidcLoginFailed(w, r, "Your identity provider hasn't verified your email address, so you can't log in with it.", nil)
+		return
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	id, err := a.identities.Login(r.Context(), idToken.Issuer, idToken.Subject, name, claims.Email)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// The provider vouches for the password, but not for the second factor
+	// the user has set up here.
+	_, err = a.twoFactor.Secret(r.Context(), id)
+	if err == nil {
+		user, err := a.users.Get(r.Context(), id)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		a.startSecondFactor(w, r, id, user.Email)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.startAuthSession(w, r, id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+// fakeOIDCProvider is a minimal OpenID Connect provider. Its authorization
+// endpoint logs in whoever the test has set, without asking, and its token
+// endpoint insists on the client secret and a PKCE verifier.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu            sync.Mutex
+	subject       string
+	email         string
+	emailVerified bool
+	codes         map[string]fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+const (
+	fakeClientID     = "snippetbox"
+	fakeClientSecret = "client-secret"
+)
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeOIDCProvider{key: key, codes: map[string]fakeAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /keys", p.keys)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *fakeOIDCProvider) setUser(subject, email string, verified bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subject, p.email, p.emailVerified = subject, email, verified
+}
+
+func (p *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *fakeOIDCProvider) keys(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   b64(p.key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+
+	p.mu.Lock()
+	p.codes[code] = fakeAuthorization{
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	p.mu.Lock()
+	auth, found := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	subject, email, verified := p.subject, p.email, p.emailVerified
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+	if id != fakeClientID || secret != fakeClientSecret || !found ||
+		r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != auth.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+
+	idToken, err := p.sign(map[string]any{
+		"iss":            p.URL,
+		"sub":            subject,
+		"aud":            fakeClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          auth.nonce,
+		"email":          email,
+		"email_verified": verified,
+		"name":           "Test User",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns claims as a JWT signed with RS256.
+func (p *fakeOIDCProvider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	signed := b64(header) + "." + b64(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + b64(signature), nil
+}
+
+// newOIDCTestServer returns a test server for an application that offers
+// single sign-on through provider.
+func newOIDCTestServer(t *testing.T, provider *fakeOIDCProvider) *testServer {
+	a := newTestApplication(t)
+
+	sso, err := newOIDCProvider(t.Context(), provider.URL, fakeClientID, fakeClientSecret,
+		a.baseURL+"/user/login/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.oidc = sso
+
+	return newTestServer(t, a.routes())
+}
+
+// loginAtProvider follows the redirect to the provider and returns the path
+// and query it sends the user back to.
+func loginAtProvider(t *testing.T, ts *testServer, provider *fakeOIDCProvider) string {
+	code, header, _ := ts.get(t, "/user/login/oidc")
+	if code != http.StatusSeeOther {
+		t.Fatalf("starting single sign-on: got status %d", code)
+	}
+
+	client := provider.Client()
+	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	rs, err := client.Get(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	if rs.StatusCode != http.StatusFound {
+		t.Fatalf("logging in at provider: got status %d", rs.StatusCode)
+	}
+
+	callback, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return callback.RequestURI()
+}
+
+func TestLoginOIDC(t *testing.T) {
+	tests := []struct {
+		name          string
+		email         string
+		emailVerified bool
+		wantLocation  string
+		wantFlash     string
+	}{
+		{
+			name:          "Existing user",
+			email:         "alice@example.com",
+			emailVerified: true,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:          "Two-factor user",
+			email:         "erin@example.com",
+			emailVerified: true,
+			wantLocation:  "/user/login/2fa",
+		},
+		{
+			name:          "Unverified email",
+			email:         "alice@example.com",
+			emailVerified: false,
+			wantLocation:  "/user/login",
+			wantFlash:     "Your identity provider hasn&#39;t verified your email address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeOIDCProvider(t)
+			provider.setUser("subject-1", tt.email, tt.emailVerified)
+
+			ts := newOIDCTestServer(t, provider)
+			defer ts.Close()
+
+			callback := loginAtProvider(t, ts, provider)
+
+			code, header, _ := ts.get(t, callback)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantLocation == "/snippet/create" {
+				code, _, _ = ts.get(t, "/snippet/create")
+				assert.Equal(t, code, http.StatusOK)
+			}
+
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/user/login")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+
+			// The callback can't be replayed.
+			code, header, _ = ts.get(t, callback)
+			assert.Equal(t, code, http.StatusSeeOther)
+			if tt.wantLocation == "/snippet/create" {
+				assert.Equal(t, header.Get("Location"), "/")
+			} else {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+		})
+	}
+}
+
+func TestLoginOIDCWrongState(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	provider.setUser("subject-1", "alice@example.com", true)
+
+	ts := newOIDCTestServer(t, provider)
+	defer ts.Close()
+
+	callback, err := url.Parse(loginAtProvider(t, ts, provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := callback.Query()
+	q.Set("state", "forged")
+	callback.RawQuery = q.Encode()
+
+	code, header, _ := ts.get(t, callback.String())
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestLoginOIDCLink(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+
+	ts := newOIDCTestServer(t, provider)
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, `<a href="/user/login/oidc">`)
+
+	ts = newTestServer(t, newTestApplication(t).routes())
+	defer ts.Close()
+
+	_, _, body = ts.get(t, "/user/login")
+	if strings.Contains(body, "/user/login/oidc") {
+		t.Error("single sign-on offered without a provider")
+	}
+
+	code, _, _ := ts.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusNotFound)
+}
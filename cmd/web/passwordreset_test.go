@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+)
+
+func TestPasswordForgot(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+		wantSent int
+	}{
+		{
+			name:     "Known address",
+			email:    "alice@example.com",
+			wantCode: http.StatusSeeOther,
+			wantSent: 1,
+		},
+		{
+			name:     "Unknown address",
+			email:    "nobody@example.com",
+			wantCode: http.StatusSeeOther,
+			wantSent: 0,
+		},
+		{
+			name:     "Invalid address",
+			email:    "alice@",
+			wantCode: http.StatusUnprocessableEntity,
+			wantSent: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/password/forgot")
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, "/user/password/forgot", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			sent := a.mailer.(*recordingMailer).messages()
+			assert.Equal(t, len(sent), tt.wantSent)
+
+			if tt.wantCode != http.StatusSeeOther {
+				return
+			}
+
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			_, _, body = ts.get(t, "/user/login")
+			assert.StringContains(t, body, "If an account uses that address")
+
+			if tt.wantSent > 0 {
+				assert.Equal(t, sent[0].To, tt.email)
+				assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/password/reset/"+mocks.MockResetToken)
+			}
+		})
+	}
+}
+
+func TestPasswordResetPage(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/user/password/reset/"+mocks.MockResetToken)
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `name="confirm"`)
+
+	code, header, _ := ts.get(t, "/user/password/reset/NOTAVALIDTOKEN")
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/password/forgot")
+}
+
+func TestPasswordResetPost(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		password     string
+		confirm      string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid submission",
+			token:        mocks.MockResetToken,
+			password:     "correct horse",
+			confirm:      "correct horse",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:     "Short password",
+			token:    mocks.MockResetToken,
+			password: "pa$$",
+			confirm:  "pa$$",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be at least 8 characters long",
+		},
+		{
+			name:     "Mismatched confirmation",
+			token:    mocks.MockResetToken,
+			password: "correct horse",
+			confirm:  "correct hosre",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The passwords do not match",
+		},
+		{
+			name:         "Invalid token",
+			token:        "NOTAVALIDTOKEN",
+			password:     "correct horse",
+			confirm:      "correct horse",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/password/forgot",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("confirm", tt.confirm)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, body := ts.postForm(t, "/user/password/reset/"+tt.token, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPasswordResetSignsOutEverywhere(t *testing.T) {
+	a := newTestApplication(t)
+	h := a.routes()
+
+	laptop := newTestServer(t, h)
+	defer laptop.Close()
+	phone := newTestServer(t, h)
+	defer phone.Close()
+
+	laptop.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := phone.get(t, "/user/password/reset/"+mocks.MockResetToken)
+
+	form := url.Values{}
+	form.Add("password", "correct horse")
+	form.Add("confirm", "correct horse")
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, _, _ := phone.postForm(t, "/user/password/reset/"+mocks.MockResetToken, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, header, _ := laptop.get(t, "/snippet/create")
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
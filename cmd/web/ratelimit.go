@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter gives every client its own token bucket, refilled at rps
+// tokens a second up to burst. Signed-in users are counted by account,
+// wherever they connect from, and everyone else by IP address.
+type rateLimiter struct {
+	rps   rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		now:     time.Now,
+		clients: map[string]*rateClient{},
+	}
+}
+
+// allow takes a token from key's bucket. If there isn't one, it reports how
+// long until there will be.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = now
+
+	reservation := c.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+
+	wait := reservation.DelayFrom(now)
+	if wait > 0 {
+		reservation.CancelAt(now)
+		return false, wait
+	}
+
+	return true, 0
+}
+
+// DeleteExpired forgets up to limit clients whose buckets would have filled
+// up again by the given time, so it can be run by a reaper like the models.
+func (rl *rateLimiter) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	refill := time.Duration(float64(rl.burst) / float64(rl.rps) * float64(time.Second))
+
+	n := 0
+	for key, c := range rl.clients {
+		if n == limit {
+			break
+		}
+		if !c.lastSeen.Add(refill).After(before) {
+			delete(rl.clients, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (a *application) rateLimitKey(r *http.Request) string {
+	if a.isAuthenticated(r) {
+		return "user:" + strconv.Itoa(a.authenticatedUserID(r))
+	}
+	return "ip:" + a.clientIP(r)
+}
+
+// rateLimit returns middleware that refuses requests beyond rl's limits with
+// 429 Too Many Requests. A nil limiter lets everything through, which is how
+// rate limiting is turned off.
+func (a *application) rateLimit(rl *rateLimiter) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		if rl == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := rl.allow(a.rateLimitKey(r))
+			if !ok {
+				setRetryAfter(w, wait)
+				a.clientError(w, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitAPI is the API counterpart of rateLimit.
+func (a *application) rateLimitAPI(rl *rateLimiter) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		if rl == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := rl.allow(a.rateLimitKey(r))
+			if !ok {
+				setRetryAfter(w, wait)
+				a.apiClientError(w, http.StatusTooManyRequests, "rate limit exceeded; slow down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
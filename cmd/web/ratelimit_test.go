@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rl := newRateLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+
+	for range 2 {
+		ok, _ := rl.allow("ip:192.0.2.1")
+		assert.Equal(t, ok, true)
+	}
+
+	ok, wait := rl.allow("ip:192.0.2.1")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, wait, time.Second)
+
+	ok, _ = rl.allow("ip:198.51.100.7")
+	assert.Equal(t, ok, true)
+
+	now = now.Add(time.Second)
+
+	ok, _ = rl.allow("ip:192.0.2.1")
+	assert.Equal(t, ok, true)
+
+	// A bucket is full again burst/rps seconds after it was last used.
+	n, err := rl.DeleteExpired(t.Context(), now.Add(time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+
+	n, err = rl.DeleteExpired(t.Context(), now.Add(2*time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
+
+func TestRateLimit(t *testing.T) {
+	a := newTestApplication(t)
+	a.readLimiter = newRateLimiter(0.01, 2)
+
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	for range 2 {
+		code, _, _ := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	code, header, _ := ts.get(t, "/")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "100")
+
+}
+
+func TestRateLimitKey(t *testing.T) {
+	a := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	assert.Equal(t, a.rateLimitKey(r), "ip:192.0.2.1")
+	assert.Equal(t, a.rateLimitKey(a.withAuthenticatedUser(r, 1)), "user:1")
+}
+
+func TestRateLimitAPI(t *testing.T) {
+	a := newTestApplication(t)
+	a.readLimiter = newRateLimiter(0.01, 1)
+
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/api/v1/snippets")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, header, body := ts.get(t, "/api/v1/snippets")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "100")
+	assert.StringContains(t, body, "rate limit exceeded")
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &application{trustedProxies: trusted}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "198.51.100.7:1234",
+			want:       "198.51.100.7",
+		},
+		{
+			name:         "Untrusted peer",
+			remoteAddr:   "198.51.100.7:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "198.51.100.7",
+		},
+		{
+			name:         "Trusted proxy",
+			remoteAddr:   "192.0.2.10:1234",
+			forwardedFor: []string{"203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		{
+			name:         "Chain of trusted proxies",
+			remoteAddr:   "10.1.2.3:1234",
+			forwardedFor: []string{"203.0.113.9, 10.4.5.6", "192.0.2.10"},
+			want:         "203.0.113.9",
+		},
+		{
+			name:         "Spoofed leftmost entry",
+			remoteAddr:   "10.1.2.3:1234",
+			forwardedFor: []string{"1.1.1.1, 203.0.113.9"},
+			want:         "203.0.113.9",
+		},
+		{
+			name:       "Trusted proxy without header",
+			remoteAddr: "10.1.2.3:1234",
+			want:       "10.1.2.3",
+		},
+		{
+			name:         "Garbage header",
+			remoteAddr:   "10.1.2.3:1234",
+			forwardedFor: []string{"not-an-ip"},
+			want:         "10.1.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			assert.Equal(t, a.clientIP(r), tt.want)
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		_, err := parseTrustedProxies(s)
+		if err == nil {
+			t.Errorf("parseTrustedProxies(%q): got nil error", s)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type expiredDeleter interface {
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// reaper periodically purges expired records, such as snippets or sessions.
+// The models already ignore them, so this only keeps the tables from growing
+// forever.
+type reaper struct {
+	kind      string
+	store     expiredDeleter
+	logger    *slog.Logger
+	interval  time.Duration
+	batchSize int
+	now       func() time.Time
+}
+
+// run reaps once straight away and then every interval until ctx is
+// cancelled.
+func (rp *reaper) run(ctx context.Context) {
+	ticker := time.NewTicker(rp.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := rp.reap(ctx)
+		if err != nil && ctx.Err() == nil {
+			rp.logger.Error("reaping expired records", "kind", rp.kind, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap deletes expired records in batches of batchSize, so no single
+// statement holds locks on the table for long, until none are left.
+func (rp *reaper) reap(ctx context.Context) (int, error) {
+	before := rp.now()
+	total := 0
+
+	for ctx.Err() == nil {
+		n, err := rp.store.DeleteExpired(ctx, before, rp.batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		if n < rp.batchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		rp.logger.Info("purged expired records", "kind", rp.kind, "count", total)
+	}
+
+	return total, nil
+}
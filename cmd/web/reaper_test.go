@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+type fakeSnippetStore struct {
+	expires []time.Time
+	calls   int
+}
+
+func (f *fakeSnippetStore) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	f.calls++
+
+	kept := []time.Time{}
+	deleted := 0
+
+	for _, e := range f.expires {
+		if !e.After(before) && deleted < limit {
+			deleted++
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	f.expires = kept
+	return deleted, nil
+}
+
+func TestReaperReap(t *testing.T) {
+	now := time.Date(2025, 5, 17, 10, 15, 0, 0, time.UTC)
+
+	store := &fakeSnippetStore{}
+	for i := range 5 {
+		store.expires = append(store.expires, now.Add(-time.Duration(i+1)*time.Hour))
+	}
+	store.expires = append(store.expires, now.Add(time.Hour), now.Add(24*time.Hour))
+
+	rp := &reaper{
+		kind:      "snippets",
+		store:     store,
+		logger:    slog.New(slog.DiscardHandler),
+		interval:  time.Hour,
+		batchSize: 2,
+		now:       func() time.Time { return now },
+	}
+
+	n, err := rp.reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, n, 5)
+	assert.Equal(t, len(store.expires), 2)
+	assert.Equal(t, store.calls, 3)
+
+	now = now.Add(2 * time.Hour)
+
+	n, err = rp.reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, n, 1)
+	assert.Equal(t, len(store.expires), 1)
+}
+
+func TestReaperRunStops(t *testing.T) {
+	rp := &reaper{
+		kind:      "snippets",
+		store:     &fakeSnippetStore{},
+		logger:    slog.New(slog.DiscardHandler),
+		interval:  time.Hour,
+		batchSize: 10,
+		now:       time.Now,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		rp.run(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after its context was cancelled")
+	}
+}
@@ -10,39 +10,118 @@ import (
 
 func (a *application) routes() http.Handler {
 
-	router := httprouter.New()
+	router := instrumentedRouter{httprouter.New(), a.metrics}
 
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	router.NotFound = a.metrics.instrument("NotFound", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		a.notFound(w)
-	})
+	}))
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 
+	// Reads and writes have separate rate limits; writes are rarer and
+	// usually dearer, from hashing passwords to sending email.
+	reading := alice.New(a.rateLimit(a.readLimiter))
+	writing := alice.New(a.rateLimit(a.writeLimiter))
+
 	protected := alice.New(a.requireAuthentication)
+	verified := protected.Append(a.requireVerified)
 	authing := alice.New(a.requireNoAuthentication)
 
 	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
 
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
-	router.HandlerFunc(http.MethodGet, "/", a.home)
-	router.HandlerFunc(http.MethodGet, "/snippet/view/:id", a.snippetView)
+	router.Handler(http.MethodGet, "/", reading.ThenFunc(a.home))
+	router.Handler(http.MethodGet, "/snippet/view/:id", reading.ThenFunc(a.snippetView))
+	router.Handler(http.MethodGet, "/snippet/raw/:id", reading.ThenFunc(a.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/download/:id", reading.ThenFunc(a.snippetDownload))
+	router.Handler(http.MethodGet, "/snippet/search", reading.ThenFunc(a.snippetSearch))
 
-	router.Handler(http.MethodGet, "/user/signup", authing.ThenFunc(a.userSignup))
-	router.Handler(http.MethodPost, "/user/signup", authing.ThenFunc(a.userSignupPost))
-	router.Handler(http.MethodGet, "/user/login", authing.ThenFunc(a.userLogin))
-	router.Handler(http.MethodPost, "/user/login", authing.ThenFunc(a.userLoginPost))
+	router.Handler(http.MethodGet, "/user/signup", reading.Extend(authing).ThenFunc(a.userSignup))
+	router.Handler(http.MethodPost, "/user/signup", writing.Extend(authing).ThenFunc(a.userSignupPost))
+	router.Handler(http.MethodGet, "/user/login", reading.Extend(authing).ThenFunc(a.userLogin))
+	router.Handler(http.MethodPost, "/user/login", writing.Extend(authing).ThenFunc(a.userLoginPost))
+	if a.oidc != nil {
+		router.Handler(http.MethodGet, "/user/login/oidc", reading.Extend(authing).ThenFunc(a.userLoginOIDC))
+		router.Handler(http.MethodGet, "/user/login/oidc/callback", writing.Extend(authing).ThenFunc(a.userLoginOIDCCallback))
+	}
+	router.Handler(http.MethodGet, "/user/login/2fa", reading.Extend(authing).ThenFunc(a.userLoginSecondFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", writing.Extend(authing).ThenFunc(a.userLoginSecondFactorPost))
+	router.Handler(http.MethodGet, "/user/password/forgot", reading.Extend(authing).ThenFunc(a.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", writing.Extend(authing).ThenFunc(a.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", reading.Extend(authing).ThenFunc(a.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", writing.Extend(authing).ThenFunc(a.userPasswordResetPost))
+	router.Handler(http.MethodGet, "/user/verify/:token", reading.ThenFunc(a.userVerify))
+	router.Handler(http.MethodPost, "/user/verify/resend", writing.ThenFunc(a.userVerifyResendPost))
 
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(a.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(a.snippetCreatePost))
-	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(a.userLogoutPost))
+	router.Handler(http.MethodGet, "/snippet/create", reading.Extend(verified).ThenFunc(a.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create", writing.Extend(verified).ThenFunc(a.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", reading.Extend(protected).ThenFunc(a.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", writing.Extend(protected).ThenFunc(a.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", writing.Extend(protected).ThenFunc(a.snippetDeletePost))
+	router.Handler(http.MethodPost, "/user/logout", writing.Extend(protected).ThenFunc(a.userLogoutPost))
+	router.Handler(http.MethodPost, "/user/logout/all", writing.Extend(protected).ThenFunc(a.userLogoutAllPost))
+	router.Handler(http.MethodGet, "/account/view", reading.Extend(protected).ThenFunc(a.accountView))
+	router.Handler(http.MethodGet, "/account/name", reading.Extend(protected).ThenFunc(a.accountName))
+	router.Handler(http.MethodPost, "/account/name", writing.Extend(protected).ThenFunc(a.accountNamePost))
+	router.Handler(http.MethodGet, "/account/email", reading.Extend(protected).ThenFunc(a.accountEmail))
+	router.Handler(http.MethodPost, "/account/email", writing.Extend(protected).ThenFunc(a.accountEmailPost))
+	router.Handler(http.MethodGet, "/account/password", reading.Extend(protected).ThenFunc(a.accountPassword))
+	router.Handler(http.MethodPost, "/account/password", writing.Extend(protected).ThenFunc(a.accountPasswordPost))
+	router.Handler(http.MethodGet, "/account/delete", reading.Extend(protected).ThenFunc(a.accountDelete))
+	router.Handler(http.MethodPost, "/account/delete", writing.Extend(protected).ThenFunc(a.accountDeletePost))
+	router.Handler(http.MethodGet, "/account/tokens", reading.Extend(protected).ThenFunc(a.accountTokens))
+	router.Handler(http.MethodPost, "/account/tokens", writing.Extend(protected).ThenFunc(a.accountTokenCreatePost))
+	router.Handler(http.MethodPost, "/account/tokens/revoke/:id", writing.Extend(protected).ThenFunc(a.accountTokenRevokePost))
+	router.Handler(http.MethodGet, "/account/2fa", reading.Extend(protected).ThenFunc(a.accountTwoFactor))
+	router.Handler(http.MethodGet, "/account/2fa/qr.png", reading.Extend(protected).ThenFunc(a.accountTwoFactorQR))
+	router.Handler(http.MethodPost, "/account/2fa/enable", writing.Extend(protected).ThenFunc(a.accountTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", writing.Extend(protected).ThenFunc(a.accountTwoFactorDisablePost))
+	router.Handler(http.MethodPost, "/account/2fa/reenroll", writing.Extend(protected).ThenFunc(a.accountTwoFactorReenrollPost))
+	router.Handler(http.MethodPost, "/account/2fa/recovery", writing.Extend(protected).ThenFunc(a.accountTwoFactorRecoveryPost))
 
-	return alice.New(
-		a.recoverPanic,
-		a.logRequest,
-		secureHeaders,
+	dynamic := alice.New(
 		a.authenticate,
 		a.noSurf,
 		a.InitializeSession,
-	).Then(router)
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", a.metrics.instrument("/healthz", http.HandlerFunc(a.healthz)))
+	mux.Handle("GET /readyz", a.metrics.instrument("/readyz", http.HandlerFunc(a.readyz)))
+	mux.Handle("/api/", a.apiRoutes())
+	mux.Handle("/", dynamic.Then(router))
+
+	return alice.New(
+		assignRequestID,
+		a.logRequest,
+		a.recoverPanic,
+		secureHeaders,
+	).Then(mux)
+}
+
+// apiRoutes serves the JSON API. It sits outside the session and CSRF
+// middleware used by the HTML pages and authenticates each request itself.
+func (a *application) apiRoutes() http.Handler {
+	router := instrumentedRouter{httprouter.New(), a.metrics}
+
+	router.NotFound = a.metrics.instrument("NotFound", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.apiNotFound(w)
+	}))
+	router.MethodNotAllowed = a.metrics.instrument("MethodNotAllowed", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.apiClientError(w, http.StatusMethodNotAllowed, "the method is not supported for this resource")
+	}))
+
+	reading := alice.New(a.rateLimitAPI(a.readLimiter))
+	writing := alice.New(a.rateLimitAPI(a.writeLimiter))
+
+	protected := alice.New(a.requireAPIAuthentication)
+	verified := protected.Append(a.requireAPIVerified)
+
+	router.Handler(http.MethodGet, "/api/v1/snippets", reading.ThenFunc(a.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", reading.ThenFunc(a.apiSnippetGet))
+	router.Handler(http.MethodPost, "/api/v1/snippets", writing.Extend(verified).ThenFunc(a.apiSnippetCreate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", writing.Extend(protected).ThenFunc(a.apiSnippetDelete))
+
+	return alice.New(a.authenticateAPI).Then(router)
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serve runs srv, along with any background tasks, until the process receives
+// SIGINT or SIGTERM. It then stops accepting connections, gives in-flight
+// requests up to shutdownTimeout to complete and waits for the tasks to
+// return. Tasks must return once their context is cancelled.
+func (a *application) serve(srv *http.Server, shutdownTimeout time.Duration, tasks ...func(context.Context)) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(ctx)
+		}()
+	}
+
+	serverError := make(chan error, 1)
+	go func() {
+		a.logger.Info("starting server", "addr", srv.Addr)
+		serverError <- srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	}()
+
+	select {
+	case err := <-serverError:
+		stop()
+		wg.Wait()
+		return err
+	case <-ctx.Done():
+	}
+
+	// Restore the default signal handling, so a second signal kills the
+	// process straight away if shutdown is taking too long.
+	stop()
+	a.logger.Info("shutting down server", "drain_delay", a.health.drainDelay)
+
+	a.health.drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	err = <-serverError
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	a.logger.Info("stopped server")
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate creates the self-signed ./tls/cert.pem and
+// ./tls/key.pem that serve expects, inside dir.
+func writeTestCertificate(t *testing.T, dir string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, "tls"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "tls", "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "tls", "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeShutsDownOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertificate(t, dir)
+	t.Chdir(dir)
+
+	a := newTestApplication(t)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: a.routes()}
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	task := func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- a.serve(srv, 5*time.Second, task)
+	}()
+
+	<-started
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after SIGTERM")
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("background task was not stopped")
+	}
+
+	if !a.health.draining.Load() {
+		t.Fatal("readiness was not withdrawn during shutdown")
+	}
+}
+
+func TestServeStartupFailure(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	a := newTestApplication(t)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: a.routes()}
+
+	stopped := make(chan struct{})
+	task := func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	}
+
+	err := a.serve(srv, time.Second, task)
+	if err == nil {
+		t.Fatal("got nil error without TLS certificates")
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("background task was not stopped")
+	}
+}
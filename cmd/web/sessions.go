@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"snippetbox.mabona3.net/internal/models"
+)
+
+// sessionStore is a sessions.Store that keeps session values in the database
+// and only a signed, random session ID in the cookie. Deleting the row
+// revokes the session, which a cookie-only store cannot do.
+type sessionStore struct {
+	sessions models.SessionModelInterface
+	codecs   []securecookie.Codec
+	Options  *sessions.Options
+}
+
+// newSessionStore returns a sessionStore whose cookies are signed with the
+// given keys, as for sessions.NewCookieStore.
+func newSessionStore(sm models.SessionModelInterface, keyPairs ...[]byte) *sessionStore {
+	s := &sessionStore{
+		sessions: sm,
+		codecs:   securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.Options.MaxAge)
+		}
+	}
+
+	return s
+}
+
+func (s *sessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A cookie that fails
+// its signature check, or names a session that has expired or been revoked,
+// gets a fresh session rather than an error.
+func (s *sessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	err = securecookie.DecodeMulti(name, c.Value, &id, s.codecs...)
+	if err != nil {
+		return session, nil
+	}
+
+	data, err := s.sessions.Find(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return session, nil
+		}
+		return session, err
+	}
+
+	err = securecookie.GobEncoder{}.Deserialize(data, &session.Values)
+	if err != nil {
+		return session, err
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Renew deletes the session's stored data and clears its ID, so that the next
+// Save issues a new one. Call it whenever the session gains privileges, such
+// as at login, so that an ID planted beforehand (session fixation) is of no
+// use to whoever planted it.
+func (s *sessionStore) Renew(r *http.Request, session *sessions.Session) error {
+	if session.ID != "" {
+		err := s.sessions.Delete(r.Context(), session.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// errSessionRevoked is returned when saving a session that was deleted after
+// it was loaded, by signing out everywhere for example. It isn't stored
+// again, since that would undo the revocation.
+var errSessionRevoked = errors.New("session revoked")
+
+// Save writes the session to the database and its ID to the cookie. A
+// session with a negative MaxAge is deleted instead, and a new session with
+// no values isn't stored at all, so anonymous page views don't create rows.
+// A session loaded from the database is only ever updated, and gives
+// errSessionRevoked if it is no longer there.
+func (s *sessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			err := s.sessions.Delete(r.Context(), session.ID)
+			if err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		if len(session.Values) == 0 {
+			return nil
+		}
+		session.ID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+
+	data, err := securecookie.GobEncoder{}.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	// A MaxAge of 0 makes a browser-session cookie, which still needs a row
+	// that outlives this request.
+	lifetime := session.Options.MaxAge
+	if lifetime == 0 {
+		lifetime = s.Options.MaxAge
+	}
+
+	userID, _ := session.Values["userId"].(int)
+	expires := time.Now().Add(time.Duration(lifetime) * time.Second)
+
+	if session.IsNew {
+		err = s.sessions.Save(r.Context(), session.ID, userID, data, expires)
+	} else {
+		err = s.sessions.Update(r.Context(), session.ID, userID, data, expires)
+		if errors.Is(err, models.ErrNoRecord) {
+			return errSessionRevoked
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Keys of the timestamps, in Unix seconds, that authenticate uses to enforce
+// the session timeouts.
+const (
+	sessionCreatedKey  = "createdAt"
+	sessionLastSeenKey = "lastSeen"
+)
+
+// startAuthSession signs the user in. The session gets a new ID, so one
+// planted before login is useless.
+func (a *application) startAuthSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	session, err := a.Store.Get(r, "authsession")
+	if err != nil {
+		return err
+	}
+
+	err = a.Store.Renew(r, session)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	session.Options.SameSite = http.SameSiteLaxMode
+	session.Options.MaxAge = int(a.sessionLifetime.Seconds())
+	session.Values = map[any]any{
+		"userId":           userID,
+		sessionCreatedKey:  now,
+		sessionLastSeenKey: now,
+	}
+
+	return session.Save(r, w)
+}
+
+// sessionExpired reports whether an authenticated session has been idle for
+// longer than the idle timeout or has outlived the absolute lifetime.
+// Sessions without the timestamps count as expired.
+func (a *application) sessionExpired(session *sessions.Session, now time.Time) bool {
+	created, ok := session.Values[sessionCreatedKey].(int64)
+	if !ok {
+		return true
+	}
+
+	lastSeen, ok := session.Values[sessionLastSeenKey].(int64)
+	if !ok {
+		return true
+	}
+
+	return now.Sub(time.Unix(created, 0)) > a.sessionLifetime || now.Sub(time.Unix(lastSeen, 0)) > a.sessionIdleTimeout
+}
+
+// touchSession slides the idle timeout forward. It only saves the session
+// once a tenth of the idle timeout (at most a minute) has passed since the
+// last save, so that not every request writes to the database.
+func (a *application) touchSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, now time.Time) error {
+	lastSeen, _ := session.Values[sessionLastSeenKey].(int64)
+
+	if now.Sub(time.Unix(lastSeen, 0)) < min(time.Minute, a.sessionIdleTimeout/10) {
+		return nil
+	}
+
+	// A loaded session has the store's default MaxAge, so set it to what is
+	// left of the lifetime or every touch would push the expiry back out.
+	created, _ := session.Values[sessionCreatedKey].(int64)
+	remaining := time.Unix(created, 0).Add(a.sessionLifetime).Sub(now)
+	session.Options.MaxAge = max(int(remaining.Seconds()), 1)
+
+	session.Values[sessionLastSeenKey] = now.Unix()
+	return session.Save(r, w)
+}
+
+// expireSession ends a timed-out session and sends the user to the login
+// page with a flash message explaining why.
+func (a *application) expireSession(w http.ResponseWriter, r *http.Request, authsession *sessions.Session) {
+	authsession.Options.MaxAge = -1
+	err := authsession.Save(r, w)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session, err := a.Store.Get(r, "session")
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session.AddFlash("Your session has expired. Please log in again.")
+	err = session.Save(r, w)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models"
+)
+
+func (ts *testServer) logout(t *testing.T, urlPath string) {
+	_, _, body := ts.get(t, "/")
+
+	form := url.Values{}
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, urlPath, form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("logout via %s: got status %d", urlPath, code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stolen *http.Cookie
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == "authsession" {
+			stolen = c
+		}
+	}
+	if stolen == nil {
+		t.Fatal("no authsession cookie after login")
+	}
+
+	ts.logout(t, "/user/logout")
+
+	r, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/create", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(stolen)
+
+	code, header, _ := ts.do(t, r)
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestLogoutAll(t *testing.T) {
+	a := newTestApplication(t)
+	h := a.routes()
+
+	laptop := newTestServer(t, h)
+	defer laptop.Close()
+	phone := newTestServer(t, h)
+	defer phone.Close()
+
+	laptop.login(t, "alice@example.com", "pa$$word")
+	phone.login(t, "alice@example.com", "pa$$word")
+
+	laptop.logout(t, "/user/logout/all")
+
+	for name, ts := range map[string]*testServer{"laptop": laptop, "phone": phone} {
+		t.Run(name, func(t *testing.T) {
+			code, header, _ := ts.get(t, "/snippet/create")
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+		})
+	}
+}
+
+// authCookie returns the authsession cookie the test server's client holds.
+func authCookie(t *testing.T, ts *testServer) *http.Cookie {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == "authsession" {
+			return c
+		}
+	}
+
+	t.Fatal("no authsession cookie")
+	return nil
+}
+
+func TestTouchRevokedSession(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+	stolen := authCookie(t, ts)
+
+	// A request loads the session just before the user signs out everywhere.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(stolen)
+
+	session, err := a.Store.New(r, "authsession")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, session.IsNew, false)
+
+	_, err = a.sessions.DeleteForUser(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.touchSession(httptest.NewRecorder(), r, session, time.Now().Add(time.Hour))
+	assert.Equal(t, errors.Is(err, errSessionRevoked), true)
+
+	_, err = a.sessions.Find(t.Context(), session.ID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	r, err = http.NewRequest(http.MethodGet, ts.URL+"/snippet/create", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(stolen)
+
+	code, header, _ := ts.do(t, r)
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestTouchSessionKeepsExpiry(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	a.sessionIdleTimeout = 30 * time.Minute
+	a.sessionLifetime = time.Hour
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(authCookie(t, ts))
+
+	session, err := a.Store.New(r, "authsession")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	err = a.touchSession(rr, r, session, time.Now().Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rr.Result().Cookies()
+	assert.Equal(t, len(cookies), 1)
+
+	// 40 minutes are left, give or take the seconds the test takes.
+	maxAge := cookies[0].MaxAge
+	assert.Equal(t, maxAge <= 40*60 && maxAge > 39*60, true)
+}
+
+func TestLoginRenewsSession(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plant a session, as an attacker fixing the victim's session ID would.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	planted, err := a.Store.New(r, "authsession")
+	if err != nil {
+		t.Fatal(err)
+	}
+	planted.Values["planted"] = true
+
+	err = a.Store.Save(r, rr, planted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar.SetCookies(u, rr.Result().Cookies())
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == "authsession" && c.Value == rr.Result().Cookies()[0].Value {
+			t.Error("login kept the planted session cookie")
+		}
+	}
+
+	_, err = a.sessions.Find(t.Context(), planted.ID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		lifetime    time.Duration
+		wantCode    int
+	}{
+		{
+			name:        "Active",
+			idleTimeout: time.Hour,
+			lifetime:    time.Hour,
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Idle too long",
+			idleTimeout: -time.Second,
+			lifetime:    time.Hour,
+			wantCode:    http.StatusSeeOther,
+		},
+		{
+			name:        "Past lifetime",
+			idleTimeout: time.Hour,
+			lifetime:    -time.Second,
+			wantCode:    http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			ts.login(t, "alice@example.com", "pa$$word")
+
+			a.sessionIdleTimeout = tt.idleTimeout
+			a.sessionLifetime = tt.lifetime
+
+			code, header, _ := ts.get(t, "/snippet/create")
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+
+				_, _, body := ts.get(t, "/user/login")
+				assert.StringContains(t, body, "Your session has expired")
+
+				code, _, _ = ts.get(t, "/snippet/create")
+				assert.Equal(t, code, http.StatusSeeOther)
+			}
+		})
+	}
+}
@@ -1,23 +1,41 @@
 package main
 
 import (
+	"bytes"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
 	"snippetbox.mabona3.net/internal/models"
 	"snippetbox.mabona3.net/ui"
 )
 
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFField       template.HTML
+	CurrentYear         int
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	Pagination          models.Pagination
+	Query               string
+	Tokens              []*models.Token
+	NewToken            string
+	TwoFactor           *twoFactorData
+	User                *models.User
+	PendingEmail        string
+	OIDCEnabled         bool
+	Form                any
+	Flash               string
+	IsAuthenticated     bool
+	AuthenticatedUserID int
+	CSRFField           template.HTML
+	Languages           []string
 }
 
 func humanDate(t time.Time) string {
@@ -28,8 +46,108 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// searchTermsRX builds a case-insensitive pattern matching any of the
+// whitespace separated words in query, or nil if there are none.
+func searchTermsRX(query string) *regexp.Regexp {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+
+	return regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+}
+
+// highlight HTML-escapes s and wraps every occurrence of a word from query in
+// a <mark> element.
+func highlight(s, query string) template.HTML {
+	rx := searchTermsRX(query)
+	if rx == nil {
+		return template.HTML(template.HTMLEscapeString(s))
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, m := range rx.FindAllStringIndex(s, -1) {
+		b.WriteString(template.HTMLEscapeString(s[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(s[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(s[last:]))
+
+	return template.HTML(b.String())
+}
+
+// excerpt returns at most n runes of s, centred on the first word from query
+// that appears in it.
+func excerpt(s, query string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	start := 0
+	if rx := searchTermsRX(query); rx != nil {
+		if loc := rx.FindStringIndex(s); loc != nil {
+			matchLen := utf8.RuneCountInString(s[loc[0]:loc[1]])
+			start = max(utf8.RuneCountInString(s[:loc[0]])-(n-matchLen)/2, 0)
+		}
+	}
+
+	runes := []rune(s)
+	end := min(start+n, len(runes))
+	start = max(end-n, 0)
+
+	out := string(runes[start:end])
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+
+	return out
+}
+
+// codeFormatter emits CSS classes rather than inline styles, so highlighted
+// snippets still render under our Content-Security-Policy. The matching
+// rules live in ui/static/css/chroma.css.
+var codeFormatter = html.New(html.WithClasses(true), html.TabWidth(4))
+
+// highlightCode renders content as syntax highlighted HTML using the lexer
+// for language, falling back to plain text for unknown languages.
+func highlightCode(content, language string) (template.HTML, error) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = codeFormatter.Format(buf, styles.Get("github"), iterator)
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":     humanDate,
+	"highlight":     highlight,
+	"excerpt":       excerpt,
+	"highlightCode": highlightCode,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"html/template"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,3 +38,131 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		query string
+		want  template.HTML
+	}{
+		{
+			name:  "Single term",
+			s:     "An old silent pond",
+			query: "pond",
+			want:  "An old silent <mark>pond</mark>",
+		},
+		{
+			name:  "Case insensitive",
+			s:     "An old silent Pond",
+			query: "pond",
+			want:  "An old silent <mark>Pond</mark>",
+		},
+		{
+			name:  "Multiple terms",
+			s:     "An old silent pond",
+			query: "old pond",
+			want:  "An <mark>old</mark> silent <mark>pond</mark>",
+		},
+		{
+			name:  "Escapes HTML",
+			s:     "<b>pond</b>",
+			query: "pond",
+			want:  "&lt;b&gt;<mark>pond</mark>&lt;/b&gt;",
+		},
+		{
+			name:  "Empty query",
+			s:     "An old silent pond",
+			query: "",
+			want:  "An old silent pond",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, highlight(tt.s, tt.query), tt.want)
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		query string
+		n     int
+		want  string
+	}{
+		{
+			name:  "Short",
+			s:     "An old silent pond",
+			query: "pond",
+			n:     100,
+			want:  "An old silent pond",
+		},
+		{
+			name:  "Centred on match",
+			s:     "aaaaaaaaaa pond bbbbbbbbbb",
+			query: "pond",
+			n:     8,
+			want:  "…a pond b…",
+		},
+		{
+			name:  "No match",
+			s:     "aaaaaaaaaa pond bbbbbbbbbb",
+			query: "frog",
+			n:     4,
+			want:  "aaaa…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, excerpt(tt.s, tt.query, tt.n), tt.want)
+		})
+	}
+}
+
+func TestHighlightCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		language string
+		want     string
+	}{
+		{
+			name:     "Go",
+			content:  "package main",
+			language: "go",
+			want:     `<span class="kn">package</span>`,
+		},
+		{
+			name:     "Escapes HTML",
+			content:  "<script>alert(1)</script>",
+			language: "plaintext",
+			want:     "&lt;script&gt;alert(1)&lt;/script&gt;",
+		},
+		{
+			name:     "Unknown language",
+			content:  "package main",
+			language: "klingon",
+			want:     "package main",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := highlightCode(tt.content, tt.language)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.StringContains(t, string(got), tt.want)
+			assert.StringContains(t, string(got), `<pre class="chroma">`)
+
+			if strings.Contains(string(got), "style=") {
+				t.Errorf("got inline style in %q", got)
+			}
+		})
+	}
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/schema"
-	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
+	"snippetbox.mabona3.net/internal/mailer"
 	"snippetbox.mabona3.net/internal/models/mocks"
 )
 
@@ -33,17 +37,62 @@ func newTestApplication(t *testing.T) *application {
 
 	godotenv.Load("../../.env")
 
+	sessionModel := &mocks.SessionModel{}
+
 	return &application{
-		errorLog:      log.New(io.Discard, "", 0),
-		infoLog:       log.New(io.Discard, "", 0),
-		snippets:      &mocks.SnippetModel{},
-		users:         &mocks.UserModel{},
-		templateCache: templateCache,
-		formDecoder:   &schema.Decoder{},
-		Store:         sessions.NewCookieStore([]byte(os.Getenv("SECRET_KEY"))),
+		logger:             slog.New(slog.DiscardHandler),
+		snippets:           &mocks.SnippetModel{},
+		users:              &mocks.UserModel{},
+		tokens:             &mocks.TokenModel{},
+		passwordResets:     &mocks.PasswordResetModel{},
+		emailVerifications: &mocks.EmailVerificationModel{},
+		twoFactor:          &mocks.TwoFactorModel{},
+		identities:         &mocks.IdentityModel{},
+		loginThrottle:      newLoginThrottle(newMemoryLoginAttempts()),
+		templateCache:      templateCache,
+		formDecoder:        schema.NewDecoder(),
+		sessions:           sessionModel,
+		Store:              newSessionStore(sessionModel, []byte(os.Getenv("SECRET_KEY"))),
+		metrics:            newMetrics(),
+		health:             &health{db: fakePinger{}, timeout: time.Second},
+		mailer:             &recordingMailer{},
+		baseURL:            "https://snippetbox.test",
+
+		sessionIdleTimeout: 30 * time.Minute,
+		sessionLifetime:    12 * time.Hour,
 	}
 }
 
+// fakePinger stands in for the database in the health checks, failing with
+// err when it is set.
+type fakePinger struct {
+	err error
+}
+
+func (p fakePinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+// recordingMailer keeps the messages sent through it, instead of sending
+// them.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
 func extractCSRFToken(t *testing.T, body string) string {
 	matches := csrfTokenRX.FindStringSubmatch(body)
 
@@ -87,7 +136,17 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 }
 
 func (ts *testServer)postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
-	rs, err := ts.Client().PostForm(ts.URL + urlPath, form)
+	r, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gorilla/csrf rejects TLS requests that carry neither an Origin nor a
+	// Referer header, which a browser would always send.
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", ts.URL)
+
+	rs, err := ts.Client().Do(r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,3 +160,32 @@ func (ts *testServer)postForm(t *testing.T, urlPath string, form url.Values) (in
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s: got status %d", email, code)
+	}
+}
+
+func (ts *testServer) do(t *testing.T, r *http.Request) (int, http.Header, string) {
+	rs, err := ts.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(body)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+// throttlePolicy describes how quickly repeated failures are slowed down.
+type throttlePolicy struct {
+	free     int           // failures allowed before any delay
+	delay    time.Duration // delay after the first failure beyond free, doubling with each one after
+	maxDelay time.Duration
+}
+
+// wait returns how long to wait after the given number of failures.
+func (p throttlePolicy) wait(failures int) time.Duration {
+	n := failures - p.free
+	if n <= 0 {
+		return 0
+	}
+
+	d := p.delay
+	for i := 1; i < n && d < p.maxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, p.maxDelay)
+}
+
+// loginThrottle slows down password guessing. Failed logins are counted
+// both against the account and against the client's IP address, and once
+// either has failed too often, further attempts are refused for an
+// exponentially growing delay, without checking the password at all.
+//
+// Each attempt is counted as a failure before the password is checked, so
+// that a burst of concurrent attempts can't all get in before any of them
+// has failed, and given back if it turns out not to be a wrong guess.
+type loginThrottle struct {
+	attempts models.LoginAttemptModelInterface
+	window   time.Duration // failures are forgotten this long after the latest one
+	account  throttlePolicy
+	client   throttlePolicy
+	now      func() time.Time
+}
+
+func newLoginThrottle(attempts models.LoginAttemptModelInterface) *loginThrottle {
+	return &loginThrottle{
+		attempts: attempts,
+		window:   24 * time.Hour,
+		// Accounts are targeted individually, so they get little leeway.
+		account: throttlePolicy{free: 5, delay: time.Minute, maxDelay: 15 * time.Minute},
+		// Many honest users can share an address behind NAT.
+		client: throttlePolicy{free: 20, delay: time.Minute, maxDelay: 15 * time.Minute},
+		now:    time.Now,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func clientKey(ip string) string {
+	return "client:" + ip
+}
+
+// attempt reserves a login for email from ip by counting it as a failure,
+// and reports 0 if it can go ahead. Otherwise nothing is counted, and it
+// reports how long the login must wait. A reserved login that doesn't turn
+// out to be a wrong guess must be given back with release or succeeded.
+func (lt *loginThrottle) attempt(ctx context.Context, email, ip string) (time.Duration, error) {
+	keys := []string{accountKey(email), clientKey(ip)}
+	policies := []throttlePolicy{lt.account, lt.client}
+
+	// Logins refused while a delay is running aren't counted, so retrying
+	// too early doesn't make it longer.
+	var wait time.Duration
+	seen := make([]int, len(keys))
+
+	for i, key := range keys {
+		failures, last, err := lt.attempts.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		seen[i] = failures
+
+		if failures == 0 {
+			continue
+		}
+
+		wait = max(wait, last.Add(policies[i].wait(failures)).Sub(lt.now()))
+	}
+
+	if wait > 0 {
+		return wait, nil
+	}
+
+	for i, key := range keys {
+		failures, err := lt.attempts.Fail(ctx, key, lt.window)
+		if err != nil {
+			return 0, err
+		}
+
+		// Other logins were reserved since the check above. This one can
+		// only go ahead if there was room for all of them.
+		if failures != seen[i]+1 && policies[i].wait(failures-1) > 0 {
+			for _, reserved := range keys[:i+1] {
+				err = lt.attempts.Release(ctx, reserved)
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return policies[i].wait(failures - 1), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// release gives back a login reserved by attempt that wasn't a wrong guess,
+// such as one with the right password that still needs a second factor.
+func (lt *loginThrottle) release(ctx context.Context, email, ip string) error {
+	for _, key := range []string{accountKey(email), clientKey(ip)} {
+		err := lt.attempts.Release(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// succeeded clears the account's failures after a login reserved by attempt
+// succeeds. The client only gets this login back, so that logging in to an
+// account of their own doesn't let an attacker carry on guessing at other
+// accounts.
+func (lt *loginThrottle) succeeded(ctx context.Context, email, ip string) error {
+	err := lt.attempts.Reset(ctx, accountKey(email))
+	if err != nil {
+		return err
+	}
+
+	return lt.attempts.Release(ctx, clientKey(ip))
+}
+
+// setRetryAfter tells the client how many seconds to wait before trying
+// again.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+}
+
+// retryMessage describes a throttling delay in words, such as "2 minutes".
+func retryMessage(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	if d <= time.Minute {
+		return plural(int((d+time.Second-1)/time.Second), "second")
+	}
+	return plural(int((d+time.Minute-1)/time.Minute), "minute")
+}
+
+// memoryLoginAttempts keeps failed login counts in memory. It is the
+// default, and is enough for a single instance; a deployment with several
+// should share the counts through the database instead.
+type memoryLoginAttempts struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempt
+	now      func() time.Time
+}
+
+type loginAttempt struct {
+	failures int
+	last     time.Time
+	expires  time.Time
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{
+		attempts: map[string]*loginAttempt{},
+		now:      time.Now,
+	}
+}
+
+func (m *memoryLoginAttempts) Get(ctx context.Context, key string) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok || !a.expires.After(m.now()) {
+		return 0, time.Time{}, nil
+	}
+
+	return a.failures, a.last, nil
+}
+
+func (m *memoryLoginAttempts) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	a, ok := m.attempts[key]
+	if !ok || !a.expires.After(now) {
+		a = &loginAttempt{}
+		m.attempts[key] = a
+	}
+
+	a.failures++
+	a.last = now
+	a.expires = now.Add(window)
+
+	return a.failures, nil
+}
+
+func (m *memoryLoginAttempts) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+func (m *memoryLoginAttempts) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *memoryLoginAttempts) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, a := range m.attempts {
+		if n == limit {
+			break
+		}
+		if !a.expires.After(before) {
+			delete(m.attempts, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
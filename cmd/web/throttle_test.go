@@ -0,0 +1,275 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestThrottlePolicyWait(t *testing.T) {
+	p := throttlePolicy{free: 5, delay: time.Minute, maxDelay: 15 * time.Minute}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "No failures",
+			failures: 0,
+			want:     0,
+		},
+		{
+			name:     "Within free failures",
+			failures: 5,
+			want:     0,
+		},
+		{
+			name:     "First delay",
+			failures: 6,
+			want:     time.Minute,
+		},
+		{
+			name:     "Doubled",
+			failures: 8,
+			want:     4 * time.Minute,
+		},
+		{
+			name:     "Capped",
+			failures: 1000,
+			want:     15 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, p.wait(tt.failures), tt.want)
+		})
+	}
+}
+
+func TestRetryMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{
+			name: "One second",
+			d:    300 * time.Millisecond,
+			want: "1 second",
+		},
+		{
+			name: "Seconds",
+			d:    59 * time.Second,
+			want: "59 seconds",
+		},
+		{
+			name: "Minutes rounded up",
+			d:    4*time.Minute + time.Second,
+			want: "5 minutes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, retryMessage(tt.d), tt.want)
+		})
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	attempts := newMemoryLoginAttempts()
+	attempts.now = clock
+
+	lt := newLoginThrottle(attempts)
+	lt.now = clock
+
+	ctx := t.Context()
+
+	// Attempts that aren't given back count as failures.
+	for range 6 {
+		wait, err := lt.attempt(ctx, "Alice@Example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, wait, time.Duration(0))
+	}
+
+	wait, err := lt.attempt(ctx, "alice@example.com", "198.51.100.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, wait, time.Minute)
+
+	wait, err = lt.attempt(ctx, "bob@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, wait, time.Duration(0))
+
+	err = lt.release(ctx, "bob@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, _, err := attempts.Get(ctx, clientKey("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 6)
+
+	now = now.Add(40 * time.Second)
+
+	// Refused attempts aren't counted, so they don't make the wait longer.
+	for range 2 {
+		wait, err = lt.attempt(ctx, "alice@example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, wait, 20*time.Second)
+	}
+
+	now = now.Add(20 * time.Second)
+
+	wait, err = lt.attempt(ctx, "alice@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, wait, time.Duration(0))
+
+	err = lt.succeeded(ctx, "alice@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, _, err = attempts.Get(ctx, accountKey("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 0)
+
+	failures, _, err = attempts.Get(ctx, clientKey("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 6)
+
+	now = now.Add(lt.window)
+
+	n, err := attempts.DeleteExpired(ctx, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 2)
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	attempts := newMemoryLoginAttempts()
+	attempts.now = clock
+
+	lt := newLoginThrottle(attempts)
+	lt.now = clock
+
+	ctx := t.Context()
+
+	// However the attempts interleave, no more get through than one after
+	// another would have.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wait, err := lt.attempt(ctx, "alice@example.com", "192.0.2.1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, allowed, lt.account.free+1)
+
+	failures, _, err := attempts.Get(ctx, accountKey("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, lt.account.free+1)
+}
+
+func TestUserLoginThrottled(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	attempt := func(password string) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/user/login")
+
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", password)
+		form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	for range a.loginThrottle.account.free + 1 {
+		code, _, _ := attempt("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	code, header, body := attempt("pa$$word")
+
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "60")
+	assert.StringContains(t, body, "Too many failed login attempts. Please try again in")
+}
+
+func TestAPIBasicAuthThrottled(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	attempt := func(password string) (int, http.Header, string) {
+		r, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/snippets/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetBasicAuth("alice@example.com", password)
+
+		return ts.do(t, r)
+	}
+
+	for range a.loginThrottle.account.free + 1 {
+		code, _, _ := attempt("wrong")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, header, body := attempt("pa$$word")
+
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "60")
+	assert.StringContains(t, body, "too many failed login attempts")
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/skip2/go-qrcode"
+	"snippetbox.mabona3.net/internal/models"
+	"snippetbox.mabona3.net/internal/totp"
+	"snippetbox.mabona3.net/internal/validator"
+)
+
+// Session keys for a login waiting on its second factor, and for a TOTP
+// secret that has been shown to the user but not yet confirmed.
+const (
+	pendingUserIDKey  = "pendingUserId"
+	pendingEmailKey   = "pendingEmail"
+	pendingAtKey      = "pendingAt"
+	totpEnrollKey     = "totpEnroll"
+	totpEnrollUserKey = "totpEnrollUserId"
+)
+
+// secondFactorTimeout is how long the user has to enter their code after
+// giving the right password.
+const secondFactorTimeout = 5 * time.Minute
+
+const totpIssuer = "Snippetbox"
+
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorData is what the two-factor settings page shows.
+type twoFactorData struct {
+	Enabled           bool
+	Secret            string // set while enrolling
+	URI               string
+	RecoveryCodes     []string // set only straight after they are generated
+	RecoveryCodesLeft int
+}
+
+// startSecondFactor remembers that the user has given the right password and
+// sends them on to enter a code. They aren't signed in until they do.
+func (a *application) startSecondFactor(w http.ResponseWriter, r *http.Request, userID int, email string) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	session.Values[pendingUserIDKey] = userID
+	session.Values[pendingEmailKey] = email
+	session.Values[pendingAtKey] = time.Now().Unix()
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// pendingLogin returns the user waiting on their second factor, if there is
+// one and they haven't taken too long.
+func pendingLogin(session *sessions.Session) (int, string, bool) {
+	userID, ok := session.Values[pendingUserIDKey].(int)
+	if !ok {
+		return 0, "", false
+	}
+
+	at, _ := session.Values[pendingAtKey].(int64)
+	if time.Since(time.Unix(at, 0)) > secondFactorTimeout {
+		return 0, "", false
+	}
+
+	email, _ := session.Values[pendingEmailKey].(string)
+
+	return userID, email, true
+}
+
+func clearPendingLogin(session *sessions.Session) {
+	delete(session.Values, pendingUserIDKey)
+	delete(session.Values, pendingEmailKey)
+	delete(session.Values, pendingAtKey)
+}
+
+// enrollingSecret returns the TOTP secret the user is part way through
+// enrolling, if any. The secret is tied to the user, since the session it is
+// kept in outlives logging out.
+func enrollingSecret(session *sessions.Session, userID int) string {
+	if id, _ := session.Values[totpEnrollUserKey].(int); id != userID {
+		return ""
+	}
+
+	secret, _ := session.Values[totpEnrollKey].(string)
+	return secret
+}
+
+func setEnrollingSecret(session *sessions.Session, userID int, secret string) {
+	if secret == "" {
+		delete(session.Values, totpEnrollKey)
+		delete(session.Values, totpEnrollUserKey)
+		return
+	}
+
+	session.Values[totpEnrollKey] = secret
+	session.Values[totpEnrollUserKey] = userID
+}
+
+// checkSecondFactor reports whether code is either the current TOTP code,
+// not already used, or one of the user's recovery codes, which is then used
+// up.
+func (a *application) checkSecondFactor(r *http.Request, userID int, code string) (bool, error) {
+	secret, err := a.twoFactor.Secret(r.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return a.twoFactor.UseStep(r.Context(), userID, step)
+	}
+
+	return a.twoFactor.UseRecoveryCode(r.Context(), userID, code)
+}
+
+func (a *application) userLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	if _, _, ok := pendingLogin(session); !ok {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := a.newTemplateData(w, r)
+	data.Form = twoFactorForm{}
+	a.render(w, r, http.StatusOK, "login2fa.html", data)
+}
+
+func (a *application) userLoginSecondFactorPost(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	userID, email, ok := pendingLogin(session)
+	if !ok {
+		session.AddFlash("Your login timed out. Please enter your password again.")
+		session.Save(r, w)
+
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "login2fa.html", data)
+		return
+	}
+
+	// Six digits are much easier to guess than a password, so codes share
+	// the password's throttling.
+	ip := a.clientIP(r)
+
+	wait, err := a.loginThrottle.attempt(r.Context(), email, ip)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		form.AddNonFieldError(fmt.Sprintf("Too many failed login attempts. Please try again in %s.", retryMessage(wait)))
+
+		setRetryAfter(w, wait)
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusTooManyRequests, "login2fa.html", data)
+		return
+	}
+
+	ok, err = a.checkSecondFactor(r, userID, form.Code)
+	if err != nil {
+		// Only a wrong code should count as a failure.
+		releaseErr := a.loginThrottle.release(r.Context(), email, ip)
+		if releaseErr != nil {
+			err = releaseErr
+		}
+
+		a.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		form.AddNonFieldError("That code isn't right. Please try again.")
+
+		data := a.newTemplateData(w, r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "login2fa.html", data)
+		return
+	}
+
+	err = a.loginThrottle.succeeded(r.Context(), email, ip)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	clearPendingLogin(session)
+	session.Save(r, w)
+
+	err = a.startAuthSession(w, r, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// twoFactorStatus gathers what the settings page shows for the signed-in
+// user, generating a secret to enroll with if they need one.
+func (a *application) twoFactorStatus(w http.ResponseWriter, r *http.Request) (*twoFactorData, error) {
+	userID := a.authenticatedUserID(r)
+
+	_, err := a.twoFactor.Secret(r.Context(), userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	status := &twoFactorData{Enabled: err == nil}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	status.Secret = enrollingSecret(session, userID)
+
+	if !status.Enabled && status.Secret == "" {
+		status.Secret, err = totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		setEnrollingSecret(session, userID, status.Secret)
+		session.Save(r, w)
+	}
+
+	if status.Secret != "" {
+		user, err := a.users.Get(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		status.URI = totp.URI(totpIssuer, user.Email, status.Secret)
+	}
+
+	if status.Enabled {
+		status.RecoveryCodesLeft, err = a.twoFactor.RecoveryCodesLeft(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
+
+// renderTwoFactor shows the settings page, with the given form and any
+// freshly generated recovery codes.
+func (a *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm, recoveryCodes []string) {
+	twoFactor, err := a.twoFactorStatus(w, r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	twoFactor.RecoveryCodes = recoveryCodes
+
+	data := a.newTemplateData(w, r)
+	data.TwoFactor = twoFactor
+	data.Form = form
+	a.render(w, r, status, "twofactor.html", data)
+}
+
+func (a *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	a.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{}, nil)
+}
+
+// accountTwoFactorQR serves the QR code of the secret being enrolled, for
+// an authenticator app to scan.
+func (a *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	userID := a.authenticatedUserID(r)
+
+	secret := enrollingSecret(session, userID)
+	if secret == "" {
+		a.notFound(w)
+		return
+	}
+
+	user, err := a.users.Get(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// accountTwoFactorEnablePost confirms the secret being enrolled with a code
+// from the user's app, and turns it on in place of any previous one.
+func (a *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+
+	userID := a.authenticatedUserID(r)
+
+	secret := enrollingSecret(session, userID)
+	if secret == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	step, valid := totp.Validate(secret, form.Code, time.Now())
+	form.CheckField(valid, "code", "That code isn't right. Check your device's clock and try again")
+
+	if !form.Valid() {
+		a.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	codes, err := a.twoFactor.Enable(r.Context(), userID, secret)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	_, err = a.twoFactor.UseStep(r.Context(), userID, step)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	setEnrollingSecret(session, userID, "")
+	session.Save(r, w)
+
+	// Like API tokens, recovery codes are only stored hashed, so this is
+	// the only time they can be shown.
+	a.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{}, codes)
+}
+
+// requireSecondFactor checks the code posted to one of the settings forms
+// that need proof the user still has their device, or a recovery code. It
+// renders the page with an error and returns false if the code is wrong.
+func (a *application) requireSecondFactor(w http.ResponseWriter, r *http.Request) bool {
+	var form twoFactorForm
+
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, http.StatusBadRequest)
+		return false
+	}
+
+	ok, err := a.checkSecondFactor(r, a.authenticatedUserID(r), form.Code)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return false
+	}
+
+	if !ok {
+		form.AddNonFieldError("That code isn't right. Please try again.")
+		a.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form, nil)
+		return false
+	}
+
+	return true
+}
+
+func (a *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	if !a.requireSecondFactor(w, r) {
+		return
+	}
+
+	userID := a.authenticatedUserID(r)
+
+	err := a.twoFactor.Disable(r.Context(), userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	setEnrollingSecret(session, userID, "")
+	session.AddFlash("Two-factor authentication has been turned off.")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+// accountTwoFactorReenrollPost starts moving two-factor authentication to a
+// new device. The old one keeps working until the new one is confirmed.
+func (a *application) accountTwoFactorReenrollPost(w http.ResponseWriter, r *http.Request) {
+	if !a.requireSecondFactor(w, r) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	session := r.Context().Value(sessionContextKey).(*sessions.Session)
+	setEnrollingSecret(session, a.authenticatedUserID(r), secret)
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+func (a *application) accountTwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
+	if !a.requireSecondFactor(w, r) {
+		return
+	}
+
+	codes, err := a.twoFactor.RegenerateRecoveryCodes(r.Context(), a.authenticatedUserID(r))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{}, codes)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+	"snippetbox.mabona3.net/internal/totp"
+)
+
+// currentTOTPCode returns the code an authenticator app would show now.
+func currentTOTPCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestLoginSecondFactor(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid TOTP code",
+			code:         currentTOTPCode(t, mocks.MockTOTPSecret),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Valid recovery code",
+			code:         mocks.MockRecoveryCode,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:     "Wrong code",
+			code:     "000000x",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "That code isn&#39;t right. Please try again.",
+		},
+		{
+			name:     "Blank code",
+			code:     "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", "erin@example.com")
+			form.Add("password", "pa$$word")
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, "/user/login", form)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+
+			// The password alone doesn't sign the user in.
+			code, header, _ = ts.get(t, "/snippet/create")
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			_, _, body = ts.get(t, "/user/login/2fa")
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, body = ts.postForm(t, "/user/login/2fa", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+				code, _, _ = ts.get(t, "/snippet/create")
+				assert.Equal(t, code, http.StatusOK)
+			}
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLoginSecondFactorWithoutPassword(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/login/2fa")
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestAccountTwoFactorEnable(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/2fa")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<img src="/account/2fa/qr.png"`)
+
+	match := regexp.MustCompile(`Key: <code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("no TOTP secret found in body")
+	}
+	secret := match[1]
+
+	// The secret is kept for the user until they confirm it.
+	_, _, again := ts.get(t, "/account/2fa")
+	assert.StringContains(t, again, secret)
+
+	code, header, _ := ts.get(t, "/account/2fa/qr.png")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "image/png")
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Wrong code",
+			code:     "000000x",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "That code isn&#39;t right",
+		},
+		{
+			name:     "Valid code",
+			code:     currentTOTPCode(t, secret),
+			wantCode: http.StatusOK,
+			wantBody: mocks.MockRecoveryCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountTwoFactorDisable(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{
+			name:     "Valid code",
+			code:     currentTOTPCode(t, mocks.MockTOTPSecret),
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Wrong code",
+			code:     "000000x",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			ts.login(t, "erin@example.com", "pa$$word")
+
+			_, _, body := ts.get(t, "/user/login/2fa")
+
+			form := url.Values{}
+			form.Add("code", mocks.MockRecoveryCode)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			_, _, body = ts.get(t, "/account/2fa")
+			assert.StringContains(t, body, "Two-factor authentication is on.")
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, _, _ = ts.postForm(t, "/account/2fa/disable", form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAPIRejectsTwoFactorBasicAuth(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/snippets", strings.NewReader(`{"title": "t", "content": "c", "expires": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetBasicAuth("erin@example.com", "pa$$word")
+
+	code, _, body := ts.do(t, r)
+
+	assert.Equal(t, code, http.StatusUnauthorized)
+	assert.StringContains(t, body, "accounts with two-factor authentication must use an API token")
+}
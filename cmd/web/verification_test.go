@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"snippetbox.mabona3.net/internal/assert"
+	"snippetbox.mabona3.net/internal/models/mocks"
+)
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+
+	form := url.Values{}
+	form.Add("name", "Dave")
+	form.Add("email", "dave@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, header, _ := ts.postForm(t, "/user/signup", form)
+
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	sent := a.mailer.(*recordingMailer).messages()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, "dave@example.com")
+	assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/verify/"+mocks.MockVerificationToken)
+}
+
+func TestLoginUnverified(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", "carol@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+	code, _, body := ts.postForm(t, "/user/login", form)
+
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.StringContains(t, body, "You need to verify your email address before you can log in.")
+	assert.StringContains(t, body, `action="/user/verify/resend"`)
+}
+
+func TestUserVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		wantFlash string
+	}{
+		{
+			name:      "Valid token",
+			token:     mocks.MockVerificationToken,
+			wantFlash: "Your email address has been verified.",
+		},
+		{
+			name:      "Invalid token",
+			token:     "NOTAVALIDTOKEN",
+			wantFlash: "That verification link is invalid or has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			code, header, _ := ts.get(t, "/user/verify/"+tt.token)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestUserVerifyResend(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		wantSent int
+	}{
+		{
+			name:     "Unverified account",
+			email:    "carol@example.com",
+			wantSent: 1,
+		},
+		{
+			name:     "Verified account",
+			email:    "alice@example.com",
+			wantSent: 0,
+		},
+		{
+			name:     "Unknown address",
+			email:    "nobody@example.com",
+			wantSent: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApplication(t)
+			ts := newTestServer(t, a.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("gorilla.csrf.Token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, "/user/verify/resend", form)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+
+			sent := a.mailer.(*recordingMailer).messages()
+			assert.Equal(t, len(sent), tt.wantSent)
+		})
+	}
+}
+
+func TestRequireVerified(t *testing.T) {
+	a := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		userID   int
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Verified",
+			userID:   1,
+			wantCode: http.StatusOK,
+			wantBody: "OK",
+		},
+		{
+			name:     "Unverified",
+			userID:   3,
+			wantCode: http.StatusForbidden,
+			wantBody: "You need to verify your email address before you can create snippets.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r := httptest.NewRequest(http.MethodGet, "/snippet/create", nil)
+			r = a.withAuthenticatedUser(r, tt.userID)
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, sessions.NewSession(a.Store, "session")))
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			})
+
+			a.requireVerified(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.StringContains(t, strings.Join(strings.Fields(rr.Body.String()), " "), tt.wantBody)
+		})
+	}
+}
+
+func TestAPIRejectsUnverifiedUser(t *testing.T) {
+	a := newTestApplication(t)
+	ts := newTestServer(t, a.routes())
+	defer ts.Close()
+
+	r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/snippets", strings.NewReader(`{"title": "t", "content": "c", "expires": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetBasicAuth("carol@example.com", "pa$$word")
+
+	code, _, body := ts.do(t, r)
+
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.StringContains(t, body, "you must verify your email address first")
+}
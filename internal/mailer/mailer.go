@@ -0,0 +1,170 @@
+// Package mailer sends the application's transactional email, such as
+// password reset links.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP delivers mail through an SMTP relay, authenticating with PLAIN auth
+// when a username is set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// sendTimeout bounds a send whose context has no deadline, so that a relay
+// which stops responding can't hold up the request waiting on it.
+const sendTimeout = 30 * time.Second
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sendTimeout)
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return fmt.Errorf("mailer: sending to %s: %w", msg.To, err)
+	}
+	defer conn.Close()
+
+	// net/smtp knows nothing of contexts, so the connection's deadline
+	// bounds the whole conversation, and cancelling ctx cuts it short.
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	err = m.deliver(conn, host, msg)
+	if err != nil {
+		// The connection can time out a moment before ctx does.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		} else if ok && errors.Is(err, os.ErrDeadlineExceeded) {
+			err = context.DeadlineExceeded
+		}
+		return fmt.Errorf("mailer: sending to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// deliver holds the same conversation with the relay as smtp.SendMail, over
+// a connection that is already open.
+func (m *SMTP) deliver(conn net.Conn, host string, msg Message) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+
+		// PlainAuth won't send the password over a connection that isn't
+		// encrypted, unless the relay is on localhost.
+		err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.From)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(m.format(msg))
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (m *SMTP) format(msg Message) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
+
+// Log writes each message to a logger instead of sending it, for
+// development and for running without a mail relay.
+type Log struct {
+	Logger *slog.Logger
+}
+
+func (m *Log) Send(ctx context.Context, msg Message) error {
+	m.Logger.InfoContext(ctx, "email not sent; no SMTP relay configured",
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestSMTPFormat(t *testing.T) {
+	m := &SMTP{From: "Snippetbox <no-reply@example.com>"}
+
+	raw := string(m.format(Message{
+		To:      "alice@example.com",
+		Subject: "Réinitialiser",
+		Body:    "line one\nline two\n",
+	}))
+
+	headers, body, found := strings.Cut(raw, "\r\n\r\n")
+	if !found {
+		t.Fatal("no blank line between headers and body")
+	}
+
+	assert.StringContains(t, headers, "From: Snippetbox <no-reply@example.com>\r\n")
+	assert.StringContains(t, headers, "To: alice@example.com\r\n")
+	assert.StringContains(t, headers, "Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n")
+	assert.StringContains(t, headers, "Content-Type: text/plain; charset=utf-8")
+	assert.Equal(t, body, "line one\r\nline two\r\n")
+}
+
+// fakeRelay accepts one SMTP connection at a time on localhost and hands it
+// to serve.
+func fakeRelay(t *testing.T, serve func(conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			serve(conn)
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestSMTPSend(t *testing.T) {
+	received := make(chan string, 1)
+
+	addr := fakeRelay(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 relay.test ESMTP")
+
+		var data strings.Builder
+		inData := false
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+
+			switch cmd, _, _ := strings.Cut(strings.TrimSpace(line), " "); strings.ToUpper(cmd) {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("500 Unrecognised command")
+			}
+		}
+	})
+
+	m := &SMTP{Addr: addr, From: "no-reply@example.com"}
+
+	err := m.Send(t.Context(), Message{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.StringContains(t, <-received, "To: alice@example.com\r\n")
+}
+
+func TestSMTPSendHungRelay(t *testing.T) {
+	// The relay accepts the connection but never says anything.
+	addr := fakeRelay(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+
+	m := &SMTP{Addr: addr, From: "no-reply@example.com"}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err := m.Send(ctx, Message{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice\n"})
+	assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+	assert.Equal(t, time.Since(start) < 5*time.Second, true)
+}
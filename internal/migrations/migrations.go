@@ -0,0 +1,239 @@
+// Package migrations holds the versioned database schema, one directory of
+// SQL files per dialect, and applies it.
+//
+// Each migration is a pair of files named NNNN_name.up.sql and
+// NNNN_name.down.sql. Statements are separated by semicolons, which must not
+// otherwise appear in the files, since not every driver can run several
+// statements in one call.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed "mysql" "sqlite"
+var files embed.FS
+
+// ErrNoChange is returned by Down when there is nothing to roll back.
+var ErrNoChange = errors.New("migrations: no migrations have been applied")
+
+type Migration struct {
+	Version int
+	Name    string
+	Applied time.Time
+	up      string
+	down    string
+}
+
+// IsApplied reports whether the migration has been applied to the database.
+func (m *Migration) IsApplied() bool {
+	return !m.Applied.IsZero()
+}
+
+// Migrator applies the migrations for one database driver, recording the
+// applied versions in the schema_migrations table.
+type Migrator struct {
+	DB         *sql.DB
+	migrations []*Migration
+}
+
+// New returns a Migrator for db, which was opened with the named driver.
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	migrations, err := load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, migrations: migrations}, nil
+}
+
+func load(driver string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: unsupported database driver %q", driver)
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		prefix, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrations: badly named file %s/%s", driver, entry.Name())
+		}
+
+		script, err := fs.ReadFile(files, path.Join(driver, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.up = string(script)
+		} else {
+			m.down = string(script)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status returns every known migration in version order, with Applied set
+// on those already applied to the database.
+func (m *Migrator) Status() ([]*Migration, error) {
+	_, err := m.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.Query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]*Migration, len(m.migrations))
+	for i, migration := range m.migrations {
+		current := *migration
+		current.Applied = applied[migration.Version]
+		status[i] = &current
+	}
+
+	return status, nil
+}
+
+// Up applies every pending migration in version order and returns the ones
+// it applied. It stops at the first failure.
+func (m *Migrator) Up() ([]*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []*Migration{}
+
+	for _, migration := range status {
+		if migration.IsApplied() {
+			continue
+		}
+
+		migration.Applied = time.Now().UTC().Truncate(time.Second)
+
+		err = m.exec(migration.up, "INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, migration.Applied)
+		if err != nil {
+			return applied, fmt.Errorf("migrations: applying %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// Down rolls back the most recently applied migration and returns it.
+func (m *Migrator) Down() (*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(status) - 1; i >= 0; i-- {
+		migration := status[i]
+		if !migration.IsApplied() {
+			continue
+		}
+
+		err = m.exec(migration.down, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		if err != nil {
+			return nil, fmt.Errorf("migrations: rolling back %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		migration.Applied = time.Time{}
+		return migration, nil
+	}
+
+	return nil, ErrNoChange
+}
+
+// exec runs script followed by the bookkeeping statement in a transaction.
+// MySQL commits implicitly after DDL, so there a failure part way through a
+// script can leave its earlier statements applied.
+func (m *Migrator) exec(script, record string, args ...any) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, stmt := range strings.Split(script, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func newTestMigrator(t *testing.T) *Migrator {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	for _, driver := range []string{"mysql", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := load(driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, m := range migrations {
+				assert.Equal(t, m.Version, i+1)
+			}
+		})
+	}
+
+	_, err := load("postgres")
+	if err == nil {
+		t.Error("got nil error for an unsupported driver")
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	m := newTestMigrator(t)
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(applied), len(m.migrations))
+
+	applied, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(applied), 0)
+
+	_, err = m.DB.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Alice', 'alice@example.com', 'x', '2025-01-01 00:00:00')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := m.migrations[len(m.migrations)-1]
+
+	rolledBack, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rolledBack.Version, last.Version)
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		assert.Equal(t, s.IsApplied(), s.Version != last.Version)
+	}
+
+	for range len(m.migrations) - 1 {
+		_, err = m.Down()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = m.Down()
+	assert.Equal(t, errors.Is(err, ErrNoChange), true)
+
+	var tables int
+	err = m.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'snippets', 'tokens')").Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tables, 0)
+}
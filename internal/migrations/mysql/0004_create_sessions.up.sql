@@ -0,0 +1,9 @@
+CREATE TABLE sessions (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NULL,
+    data BLOB NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT sessions_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_expires ON sessions (expires);
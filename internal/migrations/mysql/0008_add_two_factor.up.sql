@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    CONSTRAINT recovery_codes_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
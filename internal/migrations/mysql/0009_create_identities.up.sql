@@ -0,0 +1,8 @@
+CREATE TABLE identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT identities_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+-- Set when the snippet is edited, and NULL until then.
+ALTER TABLE snippets ADD COLUMN updated DATETIME NULL;
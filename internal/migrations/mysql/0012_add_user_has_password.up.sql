@@ -0,0 +1,4 @@
+-- FALSE for accounts created through single sign-on, until the user sets a
+-- password of their own. Existing accounts are assumed to have one, and any
+-- that don't can still set one with a password reset.
+ALTER TABLE users ADD COLUMN has_password BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,9 @@
+CREATE TABLE tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    hash BLOB NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME,
+    CONSTRAINT tokens_uc_hash UNIQUE (hash)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE sessions (
+    hash BLOB NOT NULL PRIMARY KEY,
+    user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    data BLOB NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_sessions_expires ON sessions (expires);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
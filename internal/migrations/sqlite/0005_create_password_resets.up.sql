@@ -0,0 +1,10 @@
+CREATE TABLE password_resets (
+    hash BLOB NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_password_resets_expires ON password_resets (expires);
+
+CREATE INDEX idx_password_resets_user_id ON password_resets (user_id);
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts created before verification existed are trusted as they are.
+UPDATE users SET verified = TRUE;
+
+CREATE TABLE email_verifications (
+    hash BLOB NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_email_verifications_expires ON email_verifications (expires);
+
+CREATE INDEX idx_email_verifications_user_id ON email_verifications (user_id);
@@ -0,0 +1,8 @@
+CREATE TABLE login_attempts (
+    hash BLOB NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_expires ON login_attempts (expires);
@@ -0,0 +1,2 @@
+-- Set when the token confirms a change of address rather than a new account.
+ALTER TABLE email_verifications ADD COLUMN email VARCHAR(255) NULL;
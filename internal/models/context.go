@@ -0,0 +1,16 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// queryTimeout bounds each model method's use of the database, on top of any
+// deadline already carried by the caller's context. It covers only the
+// database calls: CPU-bound work such as password hashing is done outside
+// it, so that a busy server doesn't turn slow hashes into timeouts.
+const queryTimeout = 3 * time.Second
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
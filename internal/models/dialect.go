@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Dialect is the flavour of SQL spoken by the database behind a model. The
+// zero value is MySQL.
+type Dialect int
+
+const (
+	MySQL Dialect = iota
+	SQLite
+)
+
+// DialectFor returns the dialect used with the named database/sql driver.
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "mysql":
+		return MySQL, nil
+	case "sqlite":
+		return SQLite, nil
+	default:
+		return 0, fmt.Errorf("models: unsupported database driver %q", driver)
+	}
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case MySQL:
+		return "mysql"
+	case SQLite:
+		return "sqlite"
+	default:
+		return fmt.Sprintf("Dialect(%d)", int(d))
+	}
+}
+
+// now returns the current time as it is stored in the database. Timestamps
+// are computed here rather than with functions such as UTC_TIMESTAMP(), which
+// not every dialect has, and are truncated to whole seconds so that they
+// compare the same way in both dialects.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// isUniqueViolation reports whether err was caused by a write breaking a
+// unique index. MySQL names the index in its message and SQLite names the
+// table and column, so both are needed.
+func (d Dialect) isUniqueViolation(err error, index, column string) bool {
+	switch d {
+	case MySQL:
+		var mySQLError *mysql.MySQLError
+		return errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, index)
+	case SQLite:
+		var sqliteError *sqlite.Error
+		return errors.As(err, &sqliteError) && sqliteError.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(sqliteError.Error(), column)
+	default:
+		return false
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
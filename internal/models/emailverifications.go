@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailVerificationModel manages the one-time tokens emailed to users to
+// prove that they own their address, either when they sign up or when they
+// change it. Only a hash of each token is stored.
+type EmailVerificationModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type EmailVerificationModelInterface interface {
+	Insert(ctx context.Context, userID int, ttl time.Duration) (string, error)
+	InsertEmailChange(ctx context.Context, userID int, email string, ttl time.Duration) (string, error)
+	PendingEmail(ctx context.Context, userID int) (string, error)
+	Consume(ctx context.Context, plaintext string) (int, error)
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Insert creates a token for the user that is valid for ttl and returns its
+// plaintext.
+func (m *EmailVerificationModel) Insert(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	plaintext, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	created := now()
+
+	stmt := `INSERT INTO email_verifications (hash, user_id, created, expires)
+	VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, hashOneTimeToken(plaintext), userID, created, created.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// InsertEmailChange creates a token that, once used, changes the user's
+// email address. Until then the user keeps their current address. Any
+// earlier change waiting to be confirmed is cancelled.
+func (m *EmailVerificationModel) InsertEmailChange(ctx context.Context, userID int, email string, ttl time.Duration) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	plaintext, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE user_id = ? AND email IS NOT NULL", userID)
+	if err != nil {
+		return "", err
+	}
+
+	created := now()
+
+	stmt := `INSERT INTO email_verifications (hash, user_id, email, created, expires)
+	VALUES(?, ?, ?, ?, ?)`
+
+	_, err = tx.ExecContext(ctx, stmt, hashOneTimeToken(plaintext), userID, email, created, created.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// PendingEmail returns the address the user is waiting to confirm a change
+// to, or ErrNoRecord if there isn't one.
+func (m *EmailVerificationModel) PendingEmail(ctx context.Context, userID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var email string
+
+	err := m.DB.QueryRowContext(ctx, "SELECT email FROM email_verifications WHERE user_id = ? AND email IS NOT NULL AND expires > ?",
+		userID, now()).Scan(&email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+// Consume uses up a token, along with any others outstanding for the same
+// user, marks the user as verified and returns their ID. A token for a
+// change of address also switches the user to the new address, and voids
+// any password resets sent to the old one, unless another user has taken
+// it in the meantime, which gives ErrDuplicateEmail.
+func (m *EmailVerificationModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	hash := hashOneTimeToken(plaintext)
+
+	var userID int
+	var email sql.NullString
+
+	err = tx.QueryRowContext(ctx, "SELECT user_id, email FROM email_verifications WHERE hash = ? AND expires > ?",
+		hash, now()).Scan(&userID, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE hash = ?", hash)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rows == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if email.Valid {
+		_, err = tx.ExecContext(ctx, "UPDATE users SET email = ?, verified = TRUE WHERE id = ?", email.String, userID)
+		if err != nil {
+			if m.Dialect.isUniqueViolation(err, "user_uc_email", "users.email") {
+				return 0, ErrDuplicateEmail
+			}
+			return 0, err
+		}
+
+		// Reset links already sent went to the old address, whose owner may
+		// no longer be the user's.
+		_, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ?", userID)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE users SET verified = TRUE WHERE id = ?", userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteExpired removes up to limit tokens that expired before the given
+// time and reports how many were removed.
+func (m *EmailVerificationModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM email_verifications WHERE hash IN
+	(SELECT hash FROM email_verifications WHERE expires <= ? ORDER BY expires LIMIT ?)`, before.UTC(), limit)
+	default:
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM email_verifications WHERE expires <= ? ORDER BY expires LIMIT ?", before.UTC(), limit)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
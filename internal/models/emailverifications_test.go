@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestEmailVerificationModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db, Dialect: SQLite}
+	m := &EmailVerificationModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	id, err := users.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := m.Insert(ctx, id, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Consume(ctx, expired)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	token, err := m.Insert(ctx, id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := m.Consume(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, id)
+
+	_, err = m.Consume(ctx, token)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	user, err := users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Verified, true)
+
+	_, err = users.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assert.Equal(t, err, nil)
+
+	n, err := m.DeleteExpired(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 0)
+}
+
+func TestEmailVerificationModelEmailChangeSQLite(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db, Dialect: SQLite}
+	m := &EmailVerificationModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	id, err := users.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = users.Insert(ctx, "Bob", "bob@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.PendingEmail(ctx, id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	taken, err := m.InsertEmailChange(ctx, id, "bob@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Consume(ctx, taken)
+	assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+
+	// A later change replaces the earlier one.
+	token, err := m.InsertEmailChange(ctx, id, "alice@new.example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Consume(ctx, taken)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	pending, err := m.PendingEmail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pending, "alice@new.example.com")
+
+	resets := &PasswordResetModel{DB: db, Dialect: SQLite}
+
+	reset, err := resets.Insert(ctx, id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The address only changes once the token is used.
+	user, err := users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, "alice@example.com")
+
+	userID, err := m.Consume(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, id)
+
+	user, err = users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, "alice@new.example.com")
+	assert.Equal(t, user.Verified, true)
+
+	_, err = m.PendingEmail(ctx, id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// The reset link went to the old address.
+	_, err = resets.Lookup(ctx, reset)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+}
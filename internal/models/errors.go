@@ -7,5 +7,7 @@ var (
 	ErrSessionNotFound = errors.New("session: no session found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	ErrInvalidToken = errors.New("models: invalid or expired token")
+	ErrUnverified = errors.New("models: email address not verified")
 )
 
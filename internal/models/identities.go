@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IdentityModel links users to the accounts they log in with at an external
+// OpenID Connect provider, identified by the provider's issuer URL and its
+// subject identifier for the account.
+type IdentityModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type IdentityModelInterface interface {
+	Login(ctx context.Context, issuer, subject, name, email string) (int, error)
+}
+
+// Login returns the ID of the user an external identity belongs to. The
+// first time an identity is seen, it is linked to the user with the same
+// email address, or to a new user if there isn't one, and that user is
+// marked as verified. The email address must already have been verified by
+// the provider.
+//
+// New users get a random password, so they can only log in through the
+// provider until they reset it. So does an existing user who never verified
+// their address, since whoever signed up with it may not be its owner.
+func (m *IdentityModel) Login(ctx context.Context, issuer, subject, name, email string) (int, error) {
+	userID, err := m.linkedUser(ctx, issuer, subject)
+	if err == nil {
+		return userID, nil
+	} else if !errors.Is(err, ErrNoRecord) {
+		return 0, err
+	}
+
+	// Hashing is slow, so it is done before the transaction rather than
+	// while holding it open.
+	password, err := newOneTimeToken()
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// Another login may have linked the identity in the meantime.
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM identities WHERE issuer = ? AND subject = ?",
+		issuer, subject).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	var verified bool
+
+	err = tx.QueryRowContext(ctx, "SELECT id, verified FROM users WHERE email = ?", email).Scan(&userID, &verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			userID, err = m.insertUser(ctx, tx, name, email, hashedPassword)
+			if err != nil {
+				return 0, err
+			}
+		} else {
+			return 0, err
+		}
+	} else if !verified {
+		err = m.resetUnverifiedUser(ctx, tx, userID, hashedPassword)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET verified = TRUE WHERE id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO identities (issuer, subject, user_id, created) VALUES(?, ?, ?, ?)",
+		issuer, subject, userID, now())
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (m *IdentityModel) linkedUser(ctx context.Context, issuer, subject string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var userID int
+
+	err := m.DB.QueryRowContext(ctx, "SELECT user_id FROM identities WHERE issuer = ? AND subject = ?",
+		issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (m *IdentityModel) insertUser(ctx context.Context, tx *sql.Tx, name, email string, hashedPassword []byte) (int, error) {
+	stmt := `INSERT INTO users (name, email, hashed_password, has_password, created)
+	VALUES(?, ?, ?, FALSE, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt, name, email, string(hashedPassword), now())
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err, "user_uc_email", "users.email") {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// resetUnverifiedUser takes an unverified account away from whoever signed
+// up with the address, before its owner gets it through the provider: the
+// password they chose stops working, and their sessions and API tokens end.
+func (m *IdentityModel) resetUnverifiedUser(ctx context.Context, tx *sql.Tx, userID int, hashedPassword []byte) error {
+	_, err := tx.ExecContext(ctx, "UPDATE users SET hashed_password = ?, has_password = FALSE WHERE id = ?", string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM tokens WHERE user_id = ?",
+		"DELETE FROM password_resets WHERE user_id = ?",
+	} {
+		_, err = tx.ExecContext(ctx, stmt, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestIdentityModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db, Dialect: SQLite}
+	m := &IdentityModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	const issuer = "https://idp.example.com"
+
+	aliceID, err := users.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("UPDATE users SET verified = TRUE WHERE id = ?", aliceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An identity with the same email as an existing, verified user is
+	// linked to them.
+	id, err := m.Login(ctx, issuer, "alice-sub", "Alice Jones", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, aliceID)
+
+	alice, err := users.Get(ctx, aliceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, alice.Verified, true)
+	assert.Equal(t, alice.Name, "Alice")
+	assert.Equal(t, alice.HasPassword, true)
+
+	// Once linked, the identity is found by its subject, even if the
+	// provider's email address changes.
+	id, err = m.Login(ctx, issuer, "alice-sub", "Alice Jones", "alice@new.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, aliceID)
+
+	_, err = users.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assert.Equal(t, err, nil)
+
+	// An identity without a matching user gets a new, verified one.
+	bobID, err := m.Login(ctx, issuer, "bob-sub", "Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bobID != aliceID, true)
+
+	bob, err := users.GetByEmail(ctx, "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bob.ID, bobID)
+	assert.Equal(t, bob.Name, "Bob")
+	assert.Equal(t, bob.Verified, true)
+	assert.Equal(t, bob.HasPassword, false)
+
+	// Until he sets a password of his own.
+	err = users.UpdatePassword(ctx, bobID, "bob's password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err = users.Get(ctx, bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bob.HasPassword, true)
+
+	id, err = m.Login(ctx, issuer, "bob-sub", "Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, bobID)
+
+	// The same subject at another provider is a different identity.
+	id, err = m.Login(ctx, "https://other.example.com", "alice-sub", "Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, bobID)
+}
+
+func TestIdentityModelUnverifiedUserSQLite(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db, Dialect: SQLite}
+	sessions := &SessionModel{DB: db, Dialect: SQLite}
+	tokens := &TokenModel{DB: db, Dialect: SQLite}
+	m := &IdentityModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	// Someone signs up with an address that isn't theirs, and never
+	// verifies it.
+	id, err := users.Insert(ctx, "Mallory", "victim@example.com", "mallory's password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sessions.Save(ctx, "mallory-session", id, []byte("data"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.Insert(ctx, id, "Mallory's token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The address's owner then logs in through the provider.
+	userID, err := m.Login(ctx, "https://idp.example.com", "victim-sub", "Victim", "victim@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, id)
+
+	user, err := users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Verified, true)
+	assert.Equal(t, user.HasPassword, false)
+
+	_, err = users.Authenticate(ctx, "victim@example.com", "mallory's password")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	_, err = sessions.Find(ctx, "mallory-session")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = tokens.Authenticate(ctx, token)
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+}
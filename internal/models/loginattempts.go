@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttemptModel counts recent failed logins per key, such as an email
+// address or a client IP, so that they can be throttled across every
+// instance of the application. Keys are hashed before they are stored.
+type LoginAttemptModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type LoginAttemptModelInterface interface {
+	Get(ctx context.Context, key string) (int, time.Time, error)
+	Fail(ctx context.Context, key string, window time.Duration) (int, error)
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+func hashAttemptKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// Get returns how many failures are recorded against key and when the
+// latest was. A key with no recent failures gets 0 and the zero time.
+func (m *LoginAttemptModel) Get(ctx context.Context, key string) (int, time.Time, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var failures int
+	var last time.Time
+
+	err := m.DB.QueryRowContext(ctx, "SELECT failures, last_failure FROM login_attempts WHERE hash = ? AND expires > ?",
+		hashAttemptKey(key), now()).Scan(&failures, &last)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		} else {
+			return 0, time.Time{}, err
+		}
+	}
+
+	return failures, last, nil
+}
+
+// Fail records a failure against key and returns the number recorded. The
+// count starts again once window has passed without a failure.
+func (m *LoginAttemptModel) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	hash := hashAttemptKey(key)
+	last := now()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM login_attempts WHERE hash = ? AND expires <= ?", hash, last)
+	if err != nil {
+		return 0, err
+	}
+
+	var stmt string
+
+	switch m.Dialect {
+	case SQLite:
+		stmt = `INSERT INTO login_attempts (hash, failures, last_failure, expires) VALUES (?, 1, ?, ?)
+	ON CONFLICT (hash) DO UPDATE SET failures = failures + 1, last_failure = excluded.last_failure, expires = excluded.expires`
+	default:
+		stmt = `INSERT INTO login_attempts (hash, failures, last_failure, expires) VALUES (?, 1, ?, ?)
+	ON DUPLICATE KEY UPDATE failures = failures + 1, last_failure = VALUES(last_failure), expires = VALUES(expires)`
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, hash, last, last.Add(window))
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+
+	err = tx.QueryRowContext(ctx, "SELECT failures FROM login_attempts WHERE hash = ?", hash).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Release takes back one failure recorded by Fail, for an attempt that was
+// counted before it was known whether it would fail, and didn't.
+func (m *LoginAttemptModel) Release(ctx context.Context, key string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE login_attempts SET failures = failures - 1 WHERE hash = ? AND failures > 0",
+		hashAttemptKey(key))
+	return err
+}
+
+// Reset forgets the failures recorded against key.
+func (m *LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM login_attempts WHERE hash = ?", hashAttemptKey(key))
+	return err
+}
+
+// DeleteExpired removes up to limit keys whose failures were forgotten
+// before the given time and reports how many were removed.
+func (m *LoginAttemptModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM login_attempts WHERE hash IN
+	(SELECT hash FROM login_attempts WHERE expires <= ? ORDER BY expires LIMIT ?)`, before.UTC(), limit)
+	default:
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM login_attempts WHERE expires <= ? ORDER BY expires LIMIT ?", before.UTC(), limit)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
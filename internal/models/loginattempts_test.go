@@ -0,0 +1,72 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestLoginAttemptModelSQLite(t *testing.T) {
+	m := &LoginAttemptModel{DB: newTestDB(t), Dialect: SQLite}
+	ctx := t.Context()
+
+	failures, last, err := m.Get(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 0)
+	assert.Equal(t, last.IsZero(), true)
+
+	for want := 1; want <= 3; want++ {
+		failures, err = m.Fail(ctx, "account:alice@example.com", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, failures, want)
+	}
+
+	failures, last, err = m.Get(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 3)
+	assert.Equal(t, time.Since(last) < time.Minute, true)
+
+	err = m.Release(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, _, err = m.Get(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 2)
+
+	err = m.Reset(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, _, err = m.Get(ctx, "account:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, failures, 0)
+
+	// A window that has already passed starts the count again every time.
+	for range 2 {
+		failures, err = m.Fail(ctx, "client:192.0.2.1", -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, failures, 1)
+	}
+
+	n, err := m.DeleteExpired(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
@@ -0,0 +1,35 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+const MockVerificationToken = "VERIFYVERIFYVERIFYVERIFYVERIFYVE"
+
+type EmailVerificationModel struct{}
+
+func (m *EmailVerificationModel) Insert(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	return MockVerificationToken, nil
+}
+
+func (m *EmailVerificationModel) InsertEmailChange(ctx context.Context, userID int, email string, ttl time.Duration) (string, error) {
+	return MockVerificationToken, nil
+}
+
+func (m *EmailVerificationModel) PendingEmail(ctx context.Context, userID int) (string, error) {
+	return "", models.ErrNoRecord
+}
+
+func (m *EmailVerificationModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	if plaintext == MockVerificationToken {
+		return 3, nil
+	}
+	return 0, models.ErrInvalidToken
+}
+
+func (m *EmailVerificationModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	return 0, nil
+}
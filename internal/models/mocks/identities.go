@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"context"
+)
+
+type IdentityModel struct{}
+
+func (m *IdentityModel) Login(ctx context.Context, issuer, subject, name, email string) (int, error) {
+	switch email {
+		case "alice@example.com":
+			return 1, nil
+		case "erin@example.com":
+			return 5, nil
+		case "frank@example.com":
+			return 6, nil
+		default:
+			return 4, nil
+	}
+}
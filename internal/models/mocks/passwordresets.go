@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+const MockResetToken = "RESETRESETRESETRESETRESETRESETRE"
+
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) Insert(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	return MockResetToken, nil
+}
+
+func (m *PasswordResetModel) Lookup(ctx context.Context, plaintext string) (int, error) {
+	if plaintext == MockResetToken {
+		return 1, nil
+	}
+	return 0, models.ErrInvalidToken
+}
+
+func (m *PasswordResetModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	return m.Lookup(ctx, plaintext)
+}
+
+func (m *PasswordResetModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,82 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+type mockSession struct {
+	userID  int
+	data    []byte
+	expires time.Time
+}
+
+// SessionModel keeps sessions in memory, so that tests can sign in and out
+// through the real session store.
+type SessionModel struct {
+	mu       sync.Mutex
+	sessions map[string]mockSession
+}
+
+func (m *SessionModel) Find(ctx context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !s.expires.After(time.Now()) {
+		return nil, models.ErrNoRecord
+	}
+	return s.data, nil
+}
+
+func (m *SessionModel) Save(ctx context.Context, id string, userID int, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = map[string]mockSession{}
+	}
+	m.sessions[id] = mockSession{userID: userID, data: data, expires: expires}
+	return nil
+}
+
+func (m *SessionModel) Update(ctx context.Context, id string, userID int, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !s.expires.After(time.Now()) {
+		return models.ErrNoRecord
+	}
+	m.sessions[id] = mockSession{userID: userID, data: data, expires: expires}
+	return nil
+}
+
+func (m *SessionModel) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *SessionModel) DeleteForUser(ctx context.Context, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, s := range m.sessions {
+		if s.userID == userID {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *SessionModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	return 0, nil
+}
@@ -1,34 +1,107 @@
 package mocks
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"snippetbox.mabona3.net/internal/models"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:       1,
+	UserID:   1,
+	Author:   "Alice",
+	Title:    "An old silent pond",
+	Content:  "An old silent pond...",
+	Language: "plaintext",
+	Created:  time.Now(),
+	Expires:  time.Now(),
+}
+
+var mockOtherSnippetUpdated = time.Date(2024, 1, 3, 4, 5, 6, 0, time.UTC)
+
+var mockOtherSnippet = &models.Snippet{
+	ID:       3,
+	UserID:   2,
+	Author:   "Bob",
+	Title:    "Over the wintry forest",
+	Content:  "Over the wintry forest, winds howl in rage...",
+	Language: "plaintext",
+	Created:  time.Now(),
+	Updated:  &mockOtherSnippetUpdated,
+	Expires:  time.Now(),
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title, content, language string, expires int) (int, error) {
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
 	switch id {
 		case 1:
 			return mockSnippet, nil
+		case 3:
+			return mockOtherSnippet, nil
 		default:
 			return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+func (m *SnippetModel) List(ctx context.Context, page, pageSize int) ([]*models.Snippet, models.Pagination, error) {
+	snippets := []*models.Snippet{}
+	if page == 1 {
+		snippets = append(snippets, mockSnippet)
+	}
+
+	return snippets, models.NewPagination(page, pageSize, 1), nil
+}
+
+func (m *SnippetModel) Search(ctx context.Context, query string, limit, offset int) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+
+	for _, s := range []*models.Snippet{mockSnippet, mockOtherSnippet} {
+		text := strings.ToLower(s.Title + " " + s.Content)
+		for _, term := range strings.Fields(strings.ToLower(query)) {
+			if strings.Contains(text, term) {
+				snippets = append(snippets, s)
+				break
+			}
+		}
+	}
+
+	if offset >= len(snippets) {
+		return []*models.Snippet{}, nil
+	}
+	snippets = snippets[offset:]
+
+	if len(snippets) > limit {
+		snippets = snippets[:limit]
+	}
+
+	return snippets, nil
+}
+
+func (m *SnippetModel) Update(ctx context.Context, id int, title, content, language string) error {
+	switch id {
+		case 1, 3:
+			return nil
+		default:
+			return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+		case 1, 3:
+			return nil
+		default:
+			return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	return 0, nil
 }
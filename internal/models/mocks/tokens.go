@@ -0,0 +1,53 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+const MockToken = models.TokenPrefix + "ALICEALICEALICEALICEALICEALICEAL"
+
+// MockDeletedUserToken belongs to user 2, who no longer exists.
+const MockDeletedUserToken = models.TokenPrefix + "DELETEDDELETEDDELETEDDELETEDDELE"
+
+var mockTokenRecord = &models.Token{
+	ID:      1,
+	UserID:  1,
+	Name:    "CI",
+	Created: time.Now(),
+}
+
+type TokenModel struct{}
+
+func (m *TokenModel) Insert(ctx context.Context, userID int, name string) (string, error) {
+	return MockToken, nil
+}
+
+func (m *TokenModel) List(ctx context.Context, userID int) ([]*models.Token, error) {
+	switch userID {
+	case 1:
+		return []*models.Token{mockTokenRecord}, nil
+	default:
+		return []*models.Token{}, nil
+	}
+}
+
+func (m *TokenModel) Revoke(ctx context.Context, userID, id int) error {
+	if userID == 1 && id == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *TokenModel) Authenticate(ctx context.Context, plaintext string) (int, error) {
+	switch plaintext {
+	case MockToken:
+		return 1, nil
+	case MockDeletedUserToken:
+		return 2, nil
+	default:
+		return 0, models.ErrInvalidCredentials
+	}
+}
@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"context"
+
+	"snippetbox.mabona3.net/internal/models"
+)
+
+// MockTOTPSecret is the TOTP secret of user 5, the only one with two-factor
+// authentication turned on.
+const MockTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+const MockRecoveryCode = "abcde-fghij"
+
+type TwoFactorModel struct{}
+
+func (m *TwoFactorModel) Secret(ctx context.Context, userID int) (string, error) {
+	switch userID {
+		case 5:
+			return MockTOTPSecret, nil
+		default:
+			return "", models.ErrNoRecord
+	}
+}
+
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, secret string) ([]string, error) {
+	return []string{MockRecoveryCode}, nil
+}
+
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int) error {
+	return nil
+}
+
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	return code == MockRecoveryCode, nil
+}
+
+func (m *TwoFactorModel) RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	return []string{MockRecoveryCode}, nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (int, error) {
+	return 1, nil
+}
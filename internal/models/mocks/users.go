@@ -1,30 +1,113 @@
 package mocks
 
-import "snippetbox.mabona3.net/internal/models"
+import (
+	"context"
+	"time"
+
+	"snippetbox.mabona3.net/internal/models"
+)
 
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (int, error) {
 	switch email {
 		case "dupe@example.com":
-			return models.ErrDuplicateEmail
+			return 0, models.ErrDuplicateEmail
 		default:
-			return nil
+			return 4, nil
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "pa$$word" {
-		return 1, nil
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if password != "pa$$word" {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	switch email {
+		case "alice@example.com":
+			return 1, nil
+		case "carol@example.com":
+			return 0, models.ErrUnverified
+		case "erin@example.com":
+			return 5, nil
+		default:
+			return 0, models.ErrInvalidCredentials
 	}
-	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	switch id {
-		case 1:
+		case 1, 3, 5, 6:
 			return true, nil
 		default:
 			return false, nil
 	}
 }
+
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
+	switch id {
+		case 1:
+			return &models.User{ID: 1, Name: "Alice", Email: "alice@example.com", Create: time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC), Verified: true, HasPassword: true}, nil
+		case 3:
+			return &models.User{ID: 3, Name: "Carol", Email: "carol@example.com", HasPassword: true}, nil
+		case 5:
+			return &models.User{ID: 5, Name: "Erin", Email: "erin@example.com", Verified: true, HasPassword: true}, nil
+		case 6:
+			// Frank signed up through single sign-on, and has no password.
+			return &models.User{ID: 6, Name: "Frank", Email: "frank@example.com", Verified: true}, nil
+		default:
+			return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	switch email {
+		case "alice@example.com":
+			return m.Get(ctx, 1)
+		case "carol@example.com":
+			return m.Get(ctx, 3)
+		default:
+			return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdatePassword(ctx context.Context, id int, password string) error {
+	switch id {
+		case 1, 6:
+			return nil
+		default:
+			return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	switch id {
+		case 1:
+			return nil
+		default:
+			return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) CheckPassword(ctx context.Context, id int, password string) error {
+	switch id {
+		case 1:
+			if password != "pa$$word" {
+				return models.ErrInvalidCredentials
+			}
+			return nil
+		case 6:
+			return models.ErrInvalidCredentials
+		default:
+			return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) Delete(ctx context.Context, id int) error {
+	switch id {
+		case 1, 6:
+			return nil
+		default:
+			return models.ErrNoRecord
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+)
+
+// newOneTimeToken returns a random token for an emailed link. It is safe to
+// use in a URL path.
+func newOneTimeToken() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// hashOneTimeToken is what's stored in place of an emailed token, so that a
+// leaked database can't be used to take over accounts.
+func hashOneTimeToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
@@ -0,0 +1,40 @@
+package models
+
+// Pagination describes one page of a listing along with the totals needed to
+// render navigation between pages.
+type Pagination struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+func NewPagination(page, pageSize, totalRecords int) Pagination {
+	lastPage := (totalRecords + pageSize - 1) / pageSize
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	return Pagination{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		LastPage:     lastPage,
+		TotalRecords: totalRecords,
+	}
+}
+
+func (p Pagination) HasPrevious() bool {
+	return p.CurrentPage > 1
+}
+
+func (p Pagination) HasNext() bool {
+	return p.CurrentPage < p.LastPage
+}
+
+func (p Pagination) PreviousPage() int {
+	return p.CurrentPage - 1
+}
+
+func (p Pagination) NextPage() int {
+	return p.CurrentPage + 1
+}
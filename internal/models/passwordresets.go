@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PasswordResetModel manages the one-time tokens emailed to users who have
+// forgotten their password. Only a hash of each token is stored.
+type PasswordResetModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type PasswordResetModelInterface interface {
+	Insert(ctx context.Context, userID int, ttl time.Duration) (string, error)
+	Lookup(ctx context.Context, plaintext string) (int, error)
+	Consume(ctx context.Context, plaintext string) (int, error)
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Insert creates a token for the user that is valid for ttl and returns its
+// plaintext, which is safe to use in a URL path.
+func (m *PasswordResetModel) Insert(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	plaintext, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	created := now()
+
+	stmt := `INSERT INTO password_resets (hash, user_id, created, expires)
+	VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, hashOneTimeToken(plaintext), userID, created, created.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// Lookup returns the ID of the user an unexpired token belongs to, without
+// using it up.
+func (m *PasswordResetModel) Lookup(ctx context.Context, plaintext string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var userID int
+
+	err := m.DB.QueryRowContext(ctx, "SELECT user_id FROM password_resets WHERE hash = ? AND expires > ?",
+		hashOneTimeToken(plaintext), now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Consume uses up a token, along with any others outstanding for the same
+// user, and returns the user's ID. Only one of several concurrent calls with
+// the same token succeeds.
+func (m *PasswordResetModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	hash := hashOneTimeToken(plaintext)
+
+	var userID int
+
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM password_resets WHERE hash = ? AND expires > ?",
+		hash, now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM password_resets WHERE hash = ?", hash)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rows == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteExpired removes up to limit tokens that expired before the given
+// time and reports how many were removed.
+func (m *PasswordResetModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM password_resets WHERE hash IN
+	(SELECT hash FROM password_resets WHERE expires <= ? ORDER BY expires LIMIT ?)`, before.UTC(), limit)
+	default:
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM password_resets WHERE expires <= ? ORDER BY expires LIMIT ?", before.UTC(), limit)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestPasswordResetModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Alice', 'alice@example.com', 'x', ?)`, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &PasswordResetModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	first, err := m.Insert(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.Insert(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := m.Lookup(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, 1)
+
+	userID, err = m.Consume(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, 1)
+
+	_, err = m.Consume(ctx, first)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	_, err = m.Lookup(ctx, second)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	expired, err := m.Insert(ctx, 1, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Lookup(ctx, expired)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	_, err = m.Consume(ctx, expired)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	n, err := m.DeleteExpired(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SessionModel stores web sessions server-side, so that they can be revoked.
+// Session IDs are hashed before they are stored, like API tokens.
+type SessionModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type SessionModelInterface interface {
+	Find(ctx context.Context, id string) ([]byte, error)
+	Save(ctx context.Context, id string, userID int, data []byte, expires time.Time) error
+	Update(ctx context.Context, id string, userID int, data []byte, expires time.Time) error
+	Delete(ctx context.Context, id string) error
+	DeleteForUser(ctx context.Context, userID int) (int, error)
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+func hashSessionID(id string) []byte {
+	hash := sha256.Sum256([]byte(id))
+	return hash[:]
+}
+
+// Find returns the data of the unexpired session with the given ID.
+func (m *SessionModel) Find(ctx context.Context, id string) ([]byte, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var data []byte
+
+	err := m.DB.QueryRowContext(ctx, "SELECT data FROM sessions WHERE hash = ? AND expires > ?",
+		hashSessionID(id), now()).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// Save creates or replaces the session with the given ID. A userID of 0
+// means the session does not belong to a signed-in user.
+func (m *SessionModel) Save(ctx context.Context, id string, userID int, data []byte, expires time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	owner := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+
+	var stmt string
+
+	switch m.Dialect {
+	case SQLite:
+		stmt = `INSERT INTO sessions (hash, user_id, data, expires) VALUES (?, ?, ?, ?)
+	ON CONFLICT (hash) DO UPDATE SET user_id = excluded.user_id, data = excluded.data, expires = excluded.expires`
+	default:
+		stmt = `INSERT INTO sessions (hash, user_id, data, expires) VALUES (?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), data = VALUES(data), expires = VALUES(expires)`
+	}
+
+	_, err := m.DB.ExecContext(ctx, stmt, hashSessionID(id), owner, data, expires.UTC().Truncate(time.Second))
+	return err
+}
+
+// Update replaces an existing, unexpired session. Unlike Save, it never
+// creates one, so a session that has been deleted since it was loaded, by
+// signing out everywhere for example, stays deleted and gives ErrNoRecord.
+func (m *SessionModel) Update(ctx context.Context, id string, userID int, data []byte, expires time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	owner := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	hash := hashSessionID(id)
+	current := now()
+
+	result, err := m.DB.ExecContext(ctx, "UPDATE sessions SET user_id = ?, data = ?, expires = ? WHERE hash = ? AND expires > ?",
+		owner, data, expires.UTC().Truncate(time.Second), hash, current)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL only counts rows that actually changed, so saving a session
+	// unchanged affects none, even though it is there.
+	if rows == 0 {
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT true FROM sessions WHERE hash = ? AND expires > ?)",
+			hash, current).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+
+	return nil
+}
+
+func (m *SessionModel) Delete(ctx context.Context, id string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM sessions WHERE hash = ?", hashSessionID(id))
+	return err
+}
+
+// DeleteForUser removes every session belonging to the user, signing them out
+// on all their devices, and reports how many were removed.
+func (m *SessionModel) DeleteForUser(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
+
+// DeleteExpired removes up to limit sessions that expired before the given
+// time and reports how many were removed.
+func (m *SessionModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM sessions WHERE hash IN
+	(SELECT hash FROM sessions WHERE expires <= ? ORDER BY expires LIMIT ?)`, before.UTC(), limit)
+	default:
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM sessions WHERE expires <= ? ORDER BY expires LIMIT ?", before.UTC(), limit)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestSessionModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Alice', 'alice@example.com', 'x', ?)`, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &SessionModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	err = m.Save(ctx, "anonymous", 0, []byte("flash"), tomorrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"laptop", "phone"} {
+		err = m.Save(ctx, id, 1, []byte("v1"), tomorrow)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = m.Save(ctx, "laptop", 1, []byte("v2"), tomorrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := m.Find(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(data), "v2")
+
+	err = m.Update(ctx, "laptop", 1, []byte("v3"), tomorrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = m.Find(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(data), "v3")
+
+	// Saving it unchanged still finds it.
+	err = m.Update(ctx, "laptop", 1, []byte("v3"), tomorrow)
+	assert.Equal(t, err, nil)
+
+	_, err = m.Find(ctx, "nonexistent")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	n, err := m.DeleteForUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 2)
+
+	_, err = m.Find(ctx, "phone")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// A session revoked after it was loaded can't be brought back.
+	err = m.Update(ctx, "phone", 1, []byte("v2"), tomorrow)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = m.Find(ctx, "phone")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = m.Find(ctx, "anonymous")
+	assert.Equal(t, err, nil)
+
+	err = m.Save(ctx, "stale", 0, []byte("old"), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Find(ctx, "stale")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	n, err = m.DeleteExpired(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
@@ -1,34 +1,57 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
 )
 
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int        `json:"id"`
+	UserID   int        `json:"user_id"`
+	Author   string     `json:"author,omitempty"`
+	Title    string     `json:"title"`
+	Content  string     `json:"content"`
+	Language string     `json:"language"`
+	Created  time.Time  `json:"created"`
+	Updated  *time.Time `json:"updated,omitempty"` // nil until the snippet is edited
+	Expires  time.Time  `json:"expires"`
+}
+
+// LastModified returns when the snippet was last edited, or created if it
+// never has been.
+func (s *Snippet) LastModified() time.Time {
+	if s.Updated != nil {
+		return *s.Updated
+	}
+	return s.Created
 }
 
 type SnippetModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
 }
 
 type SnippetModelInterface interface {
-	Insert(title, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Insert(ctx context.Context, userID int, title, content, language string, expires int) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	List(ctx context.Context, page, pageSize int) ([]*Snippet, Pagination, error)
+	Search(ctx context.Context, query string, limit, offset int) ([]*Snippet, error)
+	Update(ctx context.Context, id int, title, content, language string) error
+	Delete(ctx context.Context, id int) error
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
 }
 
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title, content, language string, expires int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 
-	result, err := m.DB.Exec(`INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`,
-		title, content, expires)
+	created := now()
+
+	result, err := m.DB.ExecContext(ctx, `INSERT INTO snippets (user_id, title, content, language, created, expires)
+	VALUES(?, ?, ?, ?, ?, ?)`,
+		userID, title, content, language, created, created.AddDate(0, 0, expires))
 
 	if err != nil {
 		return 0, err
@@ -42,12 +65,16 @@ func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
 	return int(id), nil
 }
 
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	s := &Snippet{}
 
-	err := m.DB.QueryRow(`SELECT id, title, content, created, expires 
-	FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`,
-		id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := m.DB.QueryRowContext(ctx, `SELECT s.id, s.user_id, u.name, s.title, s.content, s.language, s.created, s.updated, s.expires
+	FROM snippets s INNER JOIN users u ON u.id = s.user_id
+	WHERE s.expires > ? AND s.id = ?`,
+		now(), id).Scan(&s.ID, &s.UserID, &s.Author, &s.Title, &s.Content, &s.Language, &s.Created, &s.Updated, &s.Expires)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -60,29 +87,182 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	rows, err := m.DB.Query(`SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`)
+func (m *SnippetModel) List(ctx context.Context, page, pageSize int) ([]*Snippet, Pagination, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var total int
+
+	err := m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM snippets WHERE expires > ?`, now()).Scan(&total)
 	if err != nil {
-		return nil, err
+		return nil, Pagination{}, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, user_id, title, content, language, created, updated, expires FROM snippets
+	WHERE expires > ? ORDER BY id DESC LIMIT ? OFFSET ?`,
+		now(), pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, Pagination{}, err
 	}
-	
+
 	defer rows.Close()
 
 	snippet := []*Snippet{}
 
 	for rows.Next() {
 		s := &Snippet{}
-		
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Language, &s.Created, &s.Updated, &s.Expires)
 		if err != nil {
-			return nil, err
+			return nil, Pagination{}, err
 		}
 		snippet = append(snippet, s)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, Pagination{}, err
+	}
+
+	return snippet, NewPagination(page, pageSize, total), nil
+}
+
+// Search returns the live snippets matching query. MySQL uses the FULLTEXT
+// index and ranks by relevance; SQLite falls back to a substring match,
+// newest first.
+func (m *SnippetModel) Search(ctx context.Context, query string, limit, offset int) ([]*Snippet, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var rows *sql.Rows
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		pattern := "%" + escapeLike(query) + "%"
+
+		rows, err = m.DB.QueryContext(ctx, `SELECT id, user_id, title, content, language, created, updated, expires FROM snippets
+	WHERE expires > ? AND (title LIKE ? ESCAPE '\' OR content LIKE ? ESCAPE '\')
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?`,
+			now(), pattern, pattern, limit, offset)
+	default:
+		rows, err = m.DB.QueryContext(ctx, `SELECT id, user_id, title, content, language, created, updated, expires FROM snippets
+	WHERE expires > ? AND MATCH(title, content) AGAINST(? IN NATURAL LANGUAGE MODE)
+	ORDER BY MATCH(title, content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, id DESC
+	LIMIT ? OFFSET ?`,
+			now(), query, query, limit, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Language, &s.Created, &s.Updated, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return snippet, nil
+	return snippets, nil
+}
+
+// Update replaces a snippet's title, content and language. A snippet that
+// doesn't exist, or has expired, gives ErrNoRecord.
+func (m *SnippetModel) Update(ctx context.Context, id int, title, content, language string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	current := now()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE snippets SET title = ?, content = ?, language = ?, updated = ?
+	WHERE expires > ? AND id = ?`,
+		title, content, language, current, current, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL only counts rows that actually changed, so saving a snippet
+	// without changes affects none, even though it is there.
+	if rows == 0 {
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT true FROM snippets WHERE expires > ? AND id = ?)",
+			current, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+
+	return nil
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM snippets WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// DeleteExpired removes up to limit snippets that expired before the given
+// time and reports how many were removed.
+func (m *SnippetModel) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+
+	switch m.Dialect {
+	case SQLite:
+		// SQLite only accepts ORDER BY and LIMIT on DELETE when built with
+		// SQLITE_ENABLE_UPDATE_DELETE_LIMIT, so select the batch instead.
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM snippets WHERE id IN
+	(SELECT id FROM snippets WHERE expires <= ? ORDER BY expires LIMIT ?)`, before.UTC(), limit)
+	default:
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM snippets WHERE expires <= ? ORDER BY expires LIMIT ?", before.UTC(), limit)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
 }
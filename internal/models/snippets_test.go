@@ -0,0 +1,193 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func newTestSnippetModel(t *testing.T) *SnippetModel {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Alice', 'alice@example.com', 'x', ?)`, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SnippetModel{DB: db, Dialect: SQLite}
+}
+
+func TestSnippetModelSQLite(t *testing.T) {
+	m := newTestSnippetModel(t)
+
+	id, err := m.Insert(t.Context(), 1, "An old silent pond", "A frog jumps into the pond", "plaintext", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, 1)
+
+	s, err := m.Get(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Author, "Alice")
+	assert.Equal(t, s.Title, "An old silent pond")
+	assert.Equal(t, s.Expires.Sub(s.Created), 7*24*time.Hour)
+	assert.Equal(t, s.Updated == nil, true)
+	assert.Equal(t, s.LastModified(), s.Created)
+
+	err = m.Update(t.Context(), id, "Over the wintry forest", "winds howl in rage", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = m.Get(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Title, "Over the wintry forest")
+	assert.Equal(t, s.Language, "go")
+	if s.Updated == nil {
+		t.Fatal("updated time not set")
+	}
+	assert.Equal(t, s.LastModified(), *s.Updated)
+	assert.Equal(t, s.Updated.Before(s.Created), false)
+
+	// Saving without changes still finds the snippet.
+	err = m.Update(t.Context(), id, "Over the wintry forest", "winds howl in rage", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Update(t.Context(), id+1, "Over the wintry forest", "winds howl in rage", "go")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	snippets, pagination, err := m.List(t.Context(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, pagination.TotalRecords, 1)
+
+	err = m.Delete(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Get(t.Context(), id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Delete(t.Context(), id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestSnippetModelAuthorSQLite(t *testing.T) {
+	m := newTestSnippetModel(t)
+
+	_, err := m.DB.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Bob', 'bob@example.com', 'x', ?)`, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, author := range []string{"Alice", "Bob"} {
+		userID := i + 1
+
+		id, err := m.Insert(t.Context(), userID, "An old silent pond", "content", "plaintext", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := m.Get(t.Context(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s.UserID, userID)
+		assert.Equal(t, s.Author, author)
+	}
+}
+
+func TestSnippetModelSearchSQLite(t *testing.T) {
+	m := newTestSnippetModel(t)
+
+	for _, title := range []string{"100% cotton", "Snail trail", "Cotton candy"} {
+		_, err := m.Insert(t.Context(), 1, title, "content", "plaintext", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantTitle []string
+	}{
+		{
+			name:      "Case insensitive",
+			query:     "cotton",
+			wantTitle: []string{"Cotton candy", "100% cotton"},
+		},
+		{
+			name:      "Wildcard is literal",
+			query:     "%",
+			wantTitle: []string{"100% cotton"},
+		},
+		{
+			name:  "No match",
+			query: "silk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets, err := m.Search(t.Context(), tt.query, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, len(snippets), len(tt.wantTitle))
+			for i, s := range snippets {
+				assert.Equal(t, s.Title, tt.wantTitle[i])
+			}
+		})
+	}
+}
+
+func TestSnippetModelDeleteExpiredSQLite(t *testing.T) {
+	m := newTestSnippetModel(t)
+
+	for range 3 {
+		_, err := m.Insert(t.Context(), 1, "Gone tomorrow", "content", "plaintext", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.Insert(t.Context(), 1, "Here next week", "content", "plaintext", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(48 * time.Hour)
+
+	n, err := m.DeleteExpired(t.Context(), later, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 2)
+
+	n, err = m.DeleteExpired(t.Context(), later, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+
+	_, pagination, err := m.List(t.Context(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pagination.TotalRecords, 1)
+}
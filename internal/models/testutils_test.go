@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+	"snippetbox.mabona3.net/internal/migrations"
+)
+
+// newTestDB returns a fresh SQLite database with every migration applied,
+// which is removed when the test finishes.
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_time_format=sqlite"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := migrations.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// TokenPrefix marks personal API tokens so they are easy to recognise (and
+// to scan for) if they leak into logs or source code.
+const TokenPrefix = "sbx_"
+
+type Token struct {
+	ID       int
+	UserID   int
+	Name     string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+type TokenModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type TokenModelInterface interface {
+	Insert(ctx context.Context, userID int, name string) (string, error)
+	List(ctx context.Context, userID int) ([]*Token, error)
+	Revoke(ctx context.Context, userID, id int) error
+	Authenticate(ctx context.Context, plaintext string) (int, error)
+}
+
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Insert creates a new token for the user and returns its plaintext. Only a
+// hash is stored, so this is the one chance to show the token to the user.
+func (m *TokenModel) Insert(ctx context.Context, userID int, name string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := TokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	stmt := `INSERT INTO tokens (user_id, name, hash, created)
+	VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID, name, hashToken(plaintext), now())
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+func (m *TokenModel) List(ctx context.Context, userID int) ([]*Token, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, name, created, last_used FROM tokens
+	WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		t := &Token{}
+		var lastUsed sql.NullTime
+
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m *TokenModel) Revoke(ctx context.Context, userID, id int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Authenticate returns the ID of the user owning the token and records that
+// the token has been used.
+func (m *TokenModel) Authenticate(ctx context.Context, plaintext string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var id, userID int
+
+	stmt := "SELECT id, user_id FROM tokens WHERE hash = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, hashToken(plaintext)).Scan(&id, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidCredentials
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = m.DB.ExecContext(ctx, "UPDATE tokens SET last_used = ? WHERE id = ?", now(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestTokenModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, u := range []string{"alice", "bob"} {
+		_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+		VALUES (?, ?, 'x', ?)`, u, u+"@example.com", now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &TokenModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	plaintext, err := m.Insert(ctx, 1, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.HasPrefix(plaintext, TokenPrefix), true)
+
+	// Only the hash is stored.
+	var hash []byte
+	err = db.QueryRow("SELECT hash FROM tokens WHERE user_id = 1").Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(hash, hashToken(plaintext)), true)
+	assert.Equal(t, bytes.Contains(hash, []byte(plaintext)), false)
+
+	tokens, err := m.List(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(tokens), 1)
+	assert.Equal(t, tokens[0].Name, "laptop")
+	assert.Equal(t, tokens[0].LastUsed.IsZero(), true)
+
+	userID, err := m.Authenticate(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, userID, 1)
+
+	tokens, err = m.List(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tokens[0].LastUsed.IsZero(), false)
+
+	_, err = m.Authenticate(ctx, TokenPrefix+"wrong")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	// Another user can't revoke it.
+	err = m.Revoke(ctx, 2, tokens[0].ID)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = m.Authenticate(ctx, plaintext)
+	assert.Equal(t, err, nil)
+
+	err = m.Revoke(ctx, 1, tokens[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Authenticate(ctx, plaintext)
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	tokens, err = m.List(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(tokens), 0)
+}
@@ -0,0 +1,234 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+// recoveryCodeCount is how many recovery codes an account gets at a time.
+const recoveryCodeCount = 10
+
+// TwoFactorModel stores users' TOTP secrets and their one-time recovery
+// codes. Only hashes of the recovery codes are stored.
+type TwoFactorModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+type TwoFactorModelInterface interface {
+	Secret(ctx context.Context, userID int) (string, error)
+	Enable(ctx context.Context, userID int, secret string) ([]string, error)
+	Disable(ctx context.Context, userID int) error
+	UseStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error)
+	RecoveryCodesLeft(ctx context.Context, userID int) (int, error)
+}
+
+// normalizeRecoveryCode lets recovery codes be typed in either case and
+// with or without the hyphen.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// Secret returns the user's TOTP secret, or ErrNoRecord if they haven't
+// turned on two-factor authentication.
+func (m *TwoFactorModel) Secret(ctx context.Context, userID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var secret sql.NullString
+
+	err := m.DB.QueryRowContext(ctx, "SELECT totp_secret FROM users WHERE id = ?", userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	if !secret.Valid {
+		return "", ErrNoRecord
+	}
+
+	return secret.String, nil
+}
+
+// Enable turns on two-factor authentication with the given secret, or moves
+// it to a new one, and returns a fresh set of recovery codes in place of any
+// the user had.
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, secret string) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrNoRecord
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication and discards the user's
+// recovery codes.
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that the code for the given time step has been used, and
+// reports false if it, or a later one, already had been, so that an
+// intercepted code can't be replayed.
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes, reporting false
+// if it isn't one of theirs.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM recovery_codes WHERE hash = ? AND user_id = ?",
+		hashOneTimeToken(normalizeRecoveryCode(code)), userID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with a fresh
+// set and returns them.
+func (m *TwoFactorModel) RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var n int
+
+	err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for new ones, which
+// look like "k3x9q-2mfpa", within tx.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 6)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = code[:5] + "-" + code[5:]
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (hash, user_id) VALUES (?, ?)",
+			hashOneTimeToken(normalizeRecoveryCode(codes[i])), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestTwoFactorModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Alice', 'alice@example.com', 'x', ?)`, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &TwoFactorModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	_, err = m.Secret(ctx, 1)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	codes, err := m.Enable(ctx, 1, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(codes), recoveryCodeCount)
+
+	secret, err := m.Secret(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	_, err = m.Enable(ctx, 2, "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	ok, err := m.UseStep(ctx, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, true)
+
+	for _, step := range []int64{100, 99} {
+		ok, err = m.UseStep(ctx, 1, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, ok, false)
+	}
+
+	ok, err = m.UseRecoveryCode(ctx, 1, strings.ToUpper(codes[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, true)
+
+	ok, err = m.UseRecoveryCode(ctx, 1, codes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, false)
+
+	left, err := m.RecoveryCodesLeft(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, left, recoveryCodeCount-1)
+
+	fresh, err := m.RegenerateRecoveryCodes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err = m.UseRecoveryCode(ctx, 1, codes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, false)
+
+	ok, err = m.UseRecoveryCode(ctx, 1, strings.ReplaceAll(fresh[0], "-", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, true)
+
+	err = m.Disable(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Secret(ctx, 1)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	left, err = m.RecoveryCodesLeft(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, left, 0)
+}
@@ -1,12 +1,11 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"strings"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,47 +15,69 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Create         time.Time
+	Verified       bool
+	HasPassword    bool // false until a user created through single sign-on sets a password
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
 }
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
+	Insert(ctx context.Context, name, email, password string) (int, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	UpdatePassword(ctx context.Context, id int, password string) error
+	UpdateName(ctx context.Context, id int, name string) error
+	CheckPassword(ctx context.Context, id int, password string) error
+	Delete(ctx context.Context, id int) error
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert creates an unverified user and returns their ID.
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	stmt := `INSERT INTO users (name, email, hashed_password, created)
-	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	VALUES(?, ?, ?, ?)`
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.ExecContext(ctx, stmt, name, email, string(hashedPassword), now())
 	if err != nil {
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "user_uc_email") {
-				return ErrDuplicateEmail
-			}
+		if m.Dialect.isUniqueViolation(err, "user_uc_email", "users.email") {
+			return 0, ErrDuplicateEmail
 		}
-		return err
+		return 0, err
 	}
-	return nil
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// Authenticate checks a user's credentials and returns their ID. A user who
+// hasn't verified their email address gets ErrUnverified, but only once the
+// password has been checked, so that it doesn't reveal who has signed up.
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
+	var verified bool
 
-	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
+	stmt := "SELECT id, hashed_password, verified FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	ctx, cancel := withQueryTimeout(ctx)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &verified)
+	cancel()
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -74,14 +95,175 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 	}
 
+	if !verified {
+		return 0, ErrUnverified
+	}
+
 	return id, nil
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	var exists bool
 
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
+
+func (m *UserModel) Get(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	u := &User{}
+
+	stmt := "SELECT id, name, email, hashed_password, created, verified, has_password FROM users WHERE id = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.HashedPassword, &u.Create, &u.Verified, &u.HasPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	u := &User{}
+
+	stmt := "SELECT id, name, email, hashed_password, created, verified, has_password FROM users WHERE email = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.HashedPassword, &u.Create, &u.Verified, &u.HasPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) UpdatePassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "UPDATE users SET hashed_password = ?, has_password = TRUE WHERE id = ?", string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// CheckPassword returns ErrInvalidCredentials unless password is the user's
+// current password. It is for confirming sensitive changes by a user who is
+// already signed in.
+func (m *UserModel) CheckPassword(ctx context.Context, id int, password string) error {
+	var hashedPassword []byte
+
+	ctx, cancel := withQueryTimeout(ctx)
+	err := m.DB.QueryRowContext(ctx, "SELECT hashed_password FROM users WHERE id = ?", id).Scan(&hashedPassword)
+	cancel()
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the user along with their snippets. Everything else that
+// belongs to them, such as sessions and API tokens, goes with them through
+// the foreign keys.
+func (m *UserModel) Delete(ctx context.Context, id int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Snippets are public, so they are deleted explicitly rather than left
+	// to the cascade, which SQLite only applies with foreign keys turned on.
+	_, err = tx.ExecContext(ctx, "DELETE FROM snippets WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+func TestUserModelSQLite(t *testing.T) {
+	db := newTestDB(t)
+	m := &UserModel{DB: db, Dialect: SQLite}
+
+	id, err := m.Insert(t.Context(), "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, 1)
+
+	_, err = m.Insert(t.Context(), "Alice Again", "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+
+	_, err = m.Authenticate(t.Context(), "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, ErrUnverified), true)
+
+	_, err = m.Authenticate(t.Context(), "alice@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	_, err = db.Exec("UPDATE users SET verified = TRUE WHERE id = ?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := m.Get(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, "alice@example.com")
+	assert.Equal(t, user.Verified, true)
+
+	_, err = m.Get(t.Context(), 2)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	id, err = m.Authenticate(t.Context(), "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, 1)
+
+	_, err = m.Authenticate(t.Context(), "alice@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	_, err = m.Authenticate(t.Context(), "nobody@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+	tests := []struct {
+		name   string
+		userID int
+		want   bool
+	}{
+		{
+			name:   "Valid ID",
+			userID: 1,
+			want:   true,
+		},
+		{
+			name:   "Zero ID",
+			userID: 0,
+			want:   false,
+		},
+		{
+			name:   "Non-existent ID",
+			userID: 2,
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, err := m.Exists(t.Context(), tt.userID)
+
+			assert.Equal(t, exists, tt.want)
+			assert.Equal(t, err, nil)
+		})
+	}
+}
+
+func TestUserModelAccountSQLite(t *testing.T) {
+	db := newTestDB(t)
+	m := &UserModel{DB: db, Dialect: SQLite}
+	snippets := &SnippetModel{DB: db, Dialect: SQLite}
+	ctx := t.Context()
+
+	id, err := m.Insert(ctx, "Alice", "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.UpdateName(ctx, id, "Alice Jones")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := m.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Name, "Alice Jones")
+
+	err = m.UpdateName(ctx, 99, "Nobody")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	assert.Equal(t, m.CheckPassword(ctx, id, "pa$$word"), nil)
+	assert.Equal(t, errors.Is(m.CheckPassword(ctx, id, "wrong"), ErrInvalidCredentials), true)
+	assert.Equal(t, errors.Is(m.CheckPassword(ctx, 99, "pa$$word"), ErrNoRecord), true)
+
+	snippetID, err := snippets.Insert(ctx, id, "Title", "Content", "plaintext", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Delete(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Get(ctx, id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = snippets.Get(ctx, snippetID)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Delete(ctx, id)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.mabona3.net/internal/assert"
+)
+
+// rfcSecret is the ASCII secret "12345678901234567890" used by the test
+// vectors in RFC 4226 and RFC 6238.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 4226 appendix D, which RFC 6238 builds on.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for step, code := range want {
+		got, err := Code(rfcSecret, int64(step))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, got, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// RFC 6238 appendix B gives 94287082 at 59 seconds; apps show the last
+	// six digits.
+	at := time.Unix(59, 0)
+
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{
+			name:     "Current step",
+			code:     "287082",
+			at:       at,
+			wantStep: 1,
+			wantOK:   true,
+		},
+		{
+			name:     "Previous step",
+			code:     "287082",
+			at:       at.Add(30 * time.Second),
+			wantStep: 1,
+			wantOK:   true,
+		},
+		{
+			name:   "Too old",
+			code:   "287082",
+			at:     at.Add(time.Minute),
+			wantOK: false,
+		},
+		{
+			name:     "Spaces",
+			code:     "287 082",
+			at:       at,
+			wantStep: 1,
+			wantOK:   true,
+		},
+		{
+			name:   "Wrong code",
+			code:   "123456",
+			at:     at,
+			wantOK: false,
+		},
+		{
+			name:   "Wrong length",
+			code:   "28708",
+			at:     at,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, tt.at)
+
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(secret), 32)
+
+	_, err = Code(secret, 0)
+	assert.Equal(t, err, nil)
+
+	uri := URI("Snippetbox", "alice@example.com", secret)
+	assert.Equal(t, strings.HasPrefix(uri, "otpauth://totp/Snippetbox:alice@example.com?"), true)
+	assert.StringContains(t, uri, "secret="+secret)
+}